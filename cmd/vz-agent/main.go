@@ -0,0 +1,56 @@
+// vz-agent runs in the guest and relays connections forwarded by the vz
+// launcher over virtio-vsock to guest TCP ports. It also sends heartbeats to
+// the launcher, for its watchdog.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/agent"
+)
+
+func main() {
+	port := flag.Uint("port", uint(agent.Port), "vsock `port` to listen on")
+	heartbeatPort := flag.Uint("heartbeat-port", uint(agent.HeartbeatPort), "vsock `port` of the host to send heartbeats to")
+	heartbeatInterval := flag.Duration("heartbeat-interval", 5*time.Second, "`interval` between heartbeats, 0 disables them")
+	flag.Parse()
+
+	listener, err := agent.ListenVsock(uint32(*port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Listening on vsock port", *port)
+
+	if *heartbeatInterval > 0 {
+		go sendHeartbeats(uint32(*heartbeatPort), *heartbeatInterval)
+	}
+
+	log.Fatal(agent.Serve(listener, func(port uint16) (net.Conn, error) {
+		return net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	}))
+}
+
+// sendHeartbeats keeps sending heartbeats to the host port, reconnecting
+// after every failure. Hosts without a watchdog don't listen on the port, so
+// only the first of consecutive failures is logged.
+func sendHeartbeats(port uint32, interval time.Duration) {
+	failing := false
+	for {
+		conn, err := agent.DialVsock(port)
+		if err == nil {
+			log.Println("Sending heartbeats to vsock port", port)
+			failing = false
+			err = agent.SendHeartbeats(conn, interval)
+			conn.Close()
+		}
+		if !failing {
+			log.Println("Heartbeats interrupted:", err)
+			failing = true
+		}
+		time.Sleep(interval)
+	}
+}
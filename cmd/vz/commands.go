@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/console"
+	"github.com/brholstein/docker-machine-driver-vz/internal/control"
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+	"github.com/docker/machine/commands/mcndirs"
+	"github.com/pkg/errors"
+)
+
+// command operates on an already running machine. Commands are selected by
+// the first argument and run instead of the launcher.
+type command struct {
+	usage       string
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"pause": {
+		usage:       "pause <machine>",
+		description: "Pause a running machine",
+		run:         pauseCommand,
+	},
+	"resume": {
+		usage:       "resume <machine>",
+		description: "Resume a paused machine",
+		run:         resumeCommand,
+	},
+	"console": {
+		usage:       "console <machine>",
+		description: "Attach the terminal to the serial console of a running machine",
+		run:         consoleCommand,
+	},
+	"memory": {
+		usage:       "memory <machine> [<size>]",
+		description: "Show or set the memory target of a running machine, in MiB",
+		run:         memoryCommand,
+	},
+	"interfaces": {
+		usage:       "interfaces",
+		description: "List the host interfaces machines can be bridged to",
+		run:         interfacesCommand,
+	},
+	"health": {
+		usage:       "health <machine>",
+		description: "Show the health of a running machine, as tracked by its watchdog",
+		run:         healthCommand,
+	},
+	"last-exit": {
+		usage:       "last-exit <machine>",
+		description: "Show how a machine last stopped",
+		run:         lastExitCommand,
+	},
+}
+
+// runCommand runs the command named by args[0], if there is one.
+func runCommand(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return false, nil
+	}
+
+	return true, cmd.run(args[1:])
+}
+
+// printCommandsUsage describes the available commands on stderr.
+func printCommandsUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  vz %s\n    \t%s\n", commands[name].usage, commands[name].description)
+	}
+}
+
+// machineDir resolves a machine name, or a path to a machine directory,
+// to the machine directory.
+func machineDir(machine string) string {
+	if strings.ContainsRune(machine, os.PathSeparator) {
+		return machine
+	}
+	return filepath.Join(mcndirs.GetMachineDir(), machine)
+}
+
+// machineArg returns the machine directory named by the only argument in
+// args.
+func machineArg(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("Expected exactly one machine name")
+	}
+
+	return machineDir(args[0]), nil
+}
+
+// machineClient returns a control API client for the machine named by the
+// only argument in args.
+func machineClient(args []string) (*control.Client, error) {
+	dir, err := machineArg(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return control.NewClient(filepath.Join(dir, control.SocketFileName)), nil
+}
+
+func pauseCommand(args []string) error {
+	client, err := machineClient(args)
+	if err != nil {
+		return err
+	}
+
+	return client.Pause()
+}
+
+func resumeCommand(args []string) error {
+	client, err := machineClient(args)
+	if err != nil {
+		return err
+	}
+
+	return client.Resume()
+}
+
+func consoleCommand(args []string) error {
+	client, err := machineClient(args)
+	if err != nil {
+		return err
+	}
+
+	config, err := client.Config()
+	if errors.Is(err, control.ErrUnavailable) {
+		return errors.New("Machine is not running")
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, port := range config.SerialPorts {
+		if port.Type == vz.SerialPortUnix {
+			fmt.Fprintln(os.Stderr, "Connected to the console, press Ctrl-] to detach")
+			return console.Attach(port.Path, os.Stdin, os.Stdout)
+		}
+	}
+	return errors.Errorf("Machine has no %s serial port to attach to", vz.SerialPortUnix)
+}
+
+func memoryCommand(args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errors.New("Expected a machine name and optionally a size")
+	}
+
+	client, err := machineClient(args[:1])
+	if err != nil {
+		return err
+	}
+
+	var memory *control.MemoryResponse
+	if len(args) == 2 {
+		size, err := strconv.ParseUint(args[1], 10, 0)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid memory size %q", args[1])
+		}
+		memory, err = client.SetMemoryTarget(uint(size))
+		if err != nil {
+			return err
+		}
+	} else {
+		memory, err = client.Memory()
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Target:  %d MiB\n", memory.Target)
+	fmt.Printf("Maximum: %d MiB\n", memory.Maximum)
+	return nil
+}
+
+func interfacesCommand(args []string) error {
+	if len(args) != 0 {
+		return errors.New("Expected no arguments")
+	}
+
+	interfaces, err := vz.BridgeInterfaces()
+	if err != nil {
+		return err
+	}
+	for _, iface := range interfaces {
+		fmt.Printf("%s\t%s\n", iface.Identifier, iface.DisplayName)
+	}
+	return nil
+}
+
+func healthCommand(args []string) error {
+	client, err := machineClient(args)
+	if err != nil {
+		return err
+	}
+
+	health, err := client.Health()
+	if errors.Is(err, control.ErrUnavailable) {
+		return errors.New("Machine is not running")
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Health:         %s\n", health.Health)
+	if health.LastHeartbeat != nil {
+		fmt.Printf("Last heartbeat: %s\n", health.LastHeartbeat.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func lastExitCommand(args []string) error {
+	dir, err := machineArg(args)
+	if err != nil {
+		return err
+	}
+
+	status, err := vz.ReadStateFile(filepath.Join(dir, vz.StateFileName))
+	if os.IsNotExist(err) {
+		return errors.New("Machine has no state file")
+	}
+	if err != nil {
+		return err
+	}
+
+	if status.LastExit == nil {
+		fmt.Println("Machine has not stopped since it was created")
+		return nil
+	}
+
+	fmt.Printf("Reason: %s\n", status.LastExit.Reason)
+	fmt.Printf("Time:   %s\n", status.LastExit.Timestamp.Format(time.RFC3339))
+	if status.LastExit.Error != "" {
+		fmt.Printf("Error:  %s\n", status.LastExit.Error)
+	}
+
+	if len(status.Restarts) > 0 {
+		fmt.Println("Restarts:")
+	}
+	for _, restart := range status.Restarts {
+		fmt.Printf("  %d. %s %s", restart.Attempt, restart.Exit.Timestamp.Format(time.RFC3339), restart.Exit.Reason)
+		if restart.Exit.Error != "" {
+			fmt.Printf(" (%s)", restart.Exit.Error)
+		}
+		fmt.Printf(", restarted after %ds\n", restart.Backoff)
+	}
+	return nil
+}
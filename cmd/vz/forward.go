@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/agent"
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+	"github.com/pkg/errors"
+)
+
+// forwarder relays the connections accepted on a host address to a guest
+// port through vz-agent.
+type forwarder struct {
+	vm       vz.Machine
+	forward  vz.VirtualMachinePortForward
+	listener net.Listener
+}
+
+// startForwarding listens on the host address of every port forward. The
+// returned function stops listening.
+func startForwarding(vm vz.Machine, forwards []vz.VirtualMachinePortForward) (func(), error) {
+	var forwarders []*forwarder
+	stop := func() {
+		for _, f := range forwarders {
+			f.listener.Close()
+		}
+	}
+
+	for _, forward := range forwards {
+		if forward.Network == "unix" {
+			if err := os.Remove(forward.Address); err != nil && !os.IsNotExist(err) {
+				stop()
+				return nil, errors.Wrapf(err, "Failed to remove stale socket %s", forward.Address)
+			}
+		}
+
+		listener, err := net.Listen(forward.Network, forward.Address)
+		if err != nil {
+			stop()
+			return nil, errors.Wrapf(err, "Failed to forward %s to guest port %d", forward.Address, forward.GuestPort)
+		}
+
+		f := &forwarder{vm: vm, forward: forward, listener: listener}
+		forwarders = append(forwarders, f)
+		go f.serve()
+
+		log.Printf("Forwarding %s to guest port %d", forward.Address, forward.GuestPort)
+	}
+
+	return stop, nil
+}
+
+func (f *forwarder) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go f.relay(conn)
+	}
+}
+
+func (f *forwarder) relay(conn net.Conn) {
+	guest, err := f.vm.ConnectToPort(agent.Port)
+	if err != nil {
+		log.Printf("Failed to forward connection to guest port %d: %s", f.forward.GuestPort, err)
+		conn.Close()
+		return
+	}
+
+	if err := agent.Connect(guest, f.forward.GuestPort); err != nil {
+		log.Printf("Failed to forward connection to guest port %d: %s", f.forward.GuestPort, err)
+		guest.Close()
+		conn.Close()
+		return
+	}
+
+	agent.Relay(conn, guest)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/control"
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+	"github.com/pkg/errors"
+)
+
+// machine exposes a virtual machine through the control API.
+type machine struct {
+	vm        *vz.VirtualMachine
+	config    *vz.VirtualMachineConfig
+	publisher *statePublisher
+
+	// killed is closed when a forced stop is requested.
+	killed   chan struct{}
+	killOnce sync.Once
+	// stopped is closed when the virtual machine stops or fails, whether
+	// or not a stop was requested.
+	stopped chan struct{}
+
+	// quit stops watchState, which closes watchDone when it returns.
+	quit      chan struct{}
+	watchDone chan struct{}
+
+	mu       sync.Mutex
+	stopping bool
+	// restarting is set while the launcher waits to replace the stopped
+	// virtual machine.
+	restarting bool
+	// reason is why the virtual machine stopped, or was asked to.
+	reason vz.ExitReason
+	// watchdog tracks the health of the guest once it started, if the
+	// configuration asks for it.
+	watchdog *watchdog
+}
+
+var _ control.Machine = (*machine)(nil)
+
+func newMachine(vm *vz.VirtualMachine, config *vz.VirtualMachineConfig, publisher *statePublisher) *machine {
+	return &machine{
+		vm:        vm,
+		config:    config,
+		publisher: publisher,
+		killed:    make(chan struct{}),
+		stopped:   make(chan struct{}),
+		quit:      make(chan struct{}),
+		watchDone: make(chan struct{}),
+	}
+}
+
+// watchState logs and publishes every state change of the virtual machine
+// and closes m.stopped once it stops, until stopWatching is called.
+func (m *machine) watchState() {
+	defer close(m.watchDone)
+
+	log.Printf("VM state: %v", m.State())
+	m.publisher.publishState(m.State())
+
+	stoppedOnce := sync.Once{}
+	for {
+		var state vz.State
+		select {
+		case state = <-m.vm.StateChangedNotify():
+		case <-m.quit:
+			return
+		}
+		log.Printf("VM state: %v", state.String())
+
+		var reason vz.ExitReason
+		switch state {
+		case vz.StateStopped:
+			guestReason := vz.ExitReasonGuestShutdown
+			if m.vm.RebootRequested() {
+				guestReason = vz.ExitReasonGuestReboot
+			}
+			reason = m.stoppedBecause(guestReason)
+			m.publisher.publishStop(state.String(), reason, nil)
+		case vz.StateError:
+			reason = m.stoppedBecause(vz.ExitReasonVMError)
+			m.publisher.publishStop(state.String(), reason, errors.New("Virtual machine entered the error state"))
+		default:
+			m.publisher.publishState(state.String())
+			continue
+		}
+
+		stoppedOnce.Do(func() { close(m.stopped) })
+	}
+}
+
+// stopWatching stops watchState and waits for it to return.
+func (m *machine) stopWatching() {
+	close(m.quit)
+	<-m.watchDone
+}
+
+// stoppedBecause records that the virtual machine stopped, returning the
+// reason it was asked to stop, if any, or otherwise reason.
+func (m *machine) stoppedBecause(reason vz.ExitReason) vz.ExitReason {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.reason == "" {
+		m.reason = reason
+	}
+	return m.reason
+}
+
+// exitReason returns why the virtual machine stopped.
+func (m *machine) exitReason() vz.ExitReason {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reason
+}
+
+func (m *machine) isStopping() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stopping
+}
+
+// restartingState is the state reported while the launcher waits to restart
+// the virtual machine.
+const restartingState = "Restarting"
+
+func (m *machine) State() string {
+	m.mu.Lock()
+	restarting := m.restarting
+	m.mu.Unlock()
+
+	if restarting {
+		return restartingState
+	}
+	return m.vm.State().String()
+}
+
+// setRestarting marks the stopped virtual machine as about to be replaced.
+func (m *machine) setRestarting() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.restarting = true
+}
+
+func (m *machine) RequestStop() error {
+	return m.requestStop(vz.ExitReasonHostRequest)
+}
+
+// requestStop asks the guest to shut down, recording reason as the cause
+// of the shutdown.
+func (m *machine) requestStop(reason vz.ExitReason) error {
+	m.mu.Lock()
+	restarting := m.restarting
+	m.mu.Unlock()
+
+	// There is no guest to ask, stopping means not restarting.
+	if restarting {
+		return m.ForceStop()
+	}
+
+	// The stop is recorded even if the guest can't be asked, as whoever
+	// requested it may power the guest off otherwise, e.g. over SSH, which
+	// must not pass for a guest shutdown.
+	m.mu.Lock()
+	m.stopping = true
+	m.reason = reason
+	m.mu.Unlock()
+
+	if !m.vm.CanRequestStop() {
+		return errors.Errorf("Unable to request stop in state %s", m.State())
+	}
+
+	return errors.Wrap(m.vm.RequestStop(), "Failed to request stop")
+}
+
+// stopUnhealthy stops the virtual machine of a guest that stopped sending
+// heartbeats, without asking it, unless a stop is already underway.
+func (m *machine) stopUnhealthy() error {
+	m.mu.Lock()
+	if m.stopping {
+		m.mu.Unlock()
+		return nil
+	}
+	m.stopping = true
+	m.reason = vz.ExitReasonWatchdog
+	m.mu.Unlock()
+
+	return errors.Wrap(m.vm.Stop(), "Failed to stop unhealthy guest")
+}
+
+// ForceStop makes the launcher exit, which tears down the virtual machine
+// with it.
+func (m *machine) ForceStop() error {
+	m.killOnce.Do(func() { close(m.killed) })
+	return nil
+}
+
+func (m *machine) Pause() error {
+	if !m.vm.CanPause() {
+		return errors.Errorf("Unable to pause in state %s", m.State())
+	}
+
+	return errors.Wrap(m.vm.Pause(), "Failed to pause")
+}
+
+func (m *machine) Resume() error {
+	if !m.vm.CanResume() {
+		return errors.Errorf("Unable to resume in state %s", m.State())
+	}
+
+	return errors.Wrap(m.vm.Resume(), "Failed to resume")
+}
+
+// setWatchdog makes Health report the health tracked by w.
+func (m *machine) setWatchdog(w *watchdog) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.watchdog = w
+}
+
+func (m *machine) Health() (vz.Health, time.Time, error) {
+	if m.config.Watchdog == nil {
+		return "", time.Time{}, errors.New("Watchdog is not enabled")
+	}
+
+	m.mu.Lock()
+	w := m.watchdog
+	m.mu.Unlock()
+
+	if w == nil {
+		return vz.HealthWaiting, time.Time{}, nil
+	}
+	health, lastHeartbeat := w.status()
+	return health, lastHeartbeat, nil
+}
+
+func (m *machine) Config() *vz.VirtualMachineConfig {
+	return m.config
+}
+
+func (m *machine) MemoryTarget() (uint, error) {
+	size, err := m.vm.TargetMemorySize()
+	if err != nil {
+		return 0, err
+	}
+	return uint(size / (1024 * 1024)), nil
+}
+
+func (m *machine) SetMemoryTarget(size uint) error {
+	if size < vz.MinMemory || size > m.config.Memory {
+		return errors.Errorf("Memory target must be between %d and %d MiB, got %d", vz.MinMemory, m.config.Memory, size)
+	}
+
+	log.Printf("Setting memory target to %d MiB", size)
+	return errors.Wrap(m.vm.SetTargetMemorySize(uint64(size)*1024*1024), "Failed to set memory target")
+}
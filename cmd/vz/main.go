@@ -1,124 +1,348 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
-	vzog "github.com/Code-Hex/vz"
+	"github.com/brholstein/docker-machine-driver-vz/internal/control"
 	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
 	"github.com/pkg/errors"
 )
 
+// controlShutdownTimeout bounds how long in-flight control requests may
+// delay the launcher from exiting.
+const controlShutdownTimeout = 5 * time.Second
+
+// Exit codes of the launcher.
+const (
+	// exitCodeStopped means the virtual machine was shut down, by the
+	// guest or on request.
+	exitCodeStopped = 0
+	// exitCodeStartError means the virtual machine didn't start, or the
+	// launcher failed.
+	exitCodeStartError = 1
+	// exitCodeVMError means the hypervisor stopped the virtual machine
+	// because of an error.
+	exitCodeVMError = 2
+)
+
 func main() {
-	var pidFileName string
+	var (
+		pidFileName       string
+		configFileName    string
+		controlSocketName string
+		stateFileName     string
+		metricsAddress    string
+		readyFD           int
+		printSchema       bool
+	)
+
+	if ran, err := runCommand(os.Args[1:]); ran {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	flag.CommandLine.Init("", flag.ExitOnError)
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: vz [options] --config <file>")
+		flag.PrintDefaults()
+		printCommandsUsage()
+	}
 
 	flag.StringVar(&pidFileName, "pid", "", "(Optional) PID file location")
+	flag.StringVar(&configFileName, "config", "", "Configuration file location ('-' to read from stdin)")
+	flag.StringVar(&controlSocketName, "control", "", "(Optional) Control API socket location")
+	flag.StringVar(&stateFileName, "state", "", "(Optional) State file location")
+	flag.StringVar(&metricsAddress, "metrics", "", "(Optional) Serve Prometheus metrics on unix:<path> or [<host>:]<port>")
+	flag.IntVar(&readyFD, "ready-fd", -1, "(Optional) Inherited file descriptor to report on once the VM started or failed to")
+	flag.BoolVar(&printSchema, "schema", false, "Print the JSON Schema of the configuration file and exit")
 
 	flag.Parse()
 
-	if flag.NArg() != 1 {
+	if printSchema {
+		schema, err := vz.ConfigSchema()
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "Failed to generate schema"))
+		}
+		fmt.Println(string(schema))
+		return
+	}
+
+	if configFileName == "" || flag.NArg() != 0 {
 		flag.Usage()
 		log.Fatal("Configuration not provided")
 	}
 
-	var config vz.VirtualMachineConfig
+	ready := openReadiness(readyFD)
+
+	config, err := vz.ReadConfigFile(configFileName)
+	if err != nil {
+		ready.report(err)
+		log.Fatal(err)
+	}
+
+	log.Print(config)
 
-	configJson := flag.Arg(0)
-	configBytes := []byte(configJson)
+	publisher := newStatePublisher(stateFileName)
 
-	if !json.Valid(configBytes) {
-		log.Fatalf("Invalid JSON: '%s'", configJson)
+	l := &launcher{
+		backend:           vz.NewBackend(),
+		config:            config,
+		pidFileName:       pidFileName,
+		controlSocketName: controlSocketName,
+		metricsAddress:    metricsAddress,
+		publisher:         publisher,
+		ready:             ready,
 	}
 
-	if err := json.Unmarshal(configBytes, &config); err != nil {
-		log.Fatal(errors.Wrap(err, "Failed to parse config"))
+	reason, err := l.run()
+	if err != nil {
+		log.Println(err)
 	}
+	log.Println("Exiting:", reason)
+	os.Exit(exitCode(reason, err))
+}
 
-	log.Print(config)
+// exitCode returns the exit code of a launcher that stopped because of
+// reason and err.
+func exitCode(reason vz.ExitReason, err error) int {
+	switch {
+	case reason == vz.ExitReasonVMError:
+		return exitCodeVMError
+	case reason == vz.ExitReasonStartError, err != nil:
+		return exitCodeStartError
+	}
+	return exitCodeStopped
+}
 
-	if pidFileName != "" {
-		var (
-			pidFileHandle *os.File
-			err           error
-		)
+// launcher runs a virtual machine until it stops, replacing it when the
+// guest reboots.
+type launcher struct {
+	backend           vz.Backend
+	config            *vz.VirtualMachineConfig
+	pidFileName       string
+	controlSocketName string
+	metricsAddress    string
+	publisher         *statePublisher
+	ready             *readiness
+}
+
+// run starts the virtual machine and waits for it to stop other than by
+// rebooting, returning why it stopped.
+func (l *launcher) run() (reason vz.ExitReason, err error) {
+	// Reported last, once the PID and state files are settled.
+	defer func() {
+		if reason == vz.ExitReasonStartError {
+			l.ready.report(err)
+		}
+	}()
 
-		pidFileHandle, err = os.OpenFile(pidFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	if l.pidFileName != "" {
+		pidFileHandle, err := os.OpenFile(l.pidFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
 		if err != nil {
-			log.Fatal(errors.Wrap(err, "Unable to create PID file"))
+			return vz.ExitReasonStartError, errors.Wrap(err, "Unable to create PID file")
 		}
 		defer pidFileHandle.Close()
 		defer os.Remove(pidFileHandle.Name())
 
 		_, err = pidFileHandle.WriteString(fmt.Sprintf("%d", os.Getpid()))
 		if err != nil {
-			log.Fatal(errors.Wrap(err, "Failed to write PID file"))
+			return vz.ExitReasonStartError, errors.Wrap(err, "Failed to write PID file")
 		}
 		pidFileHandle.Sync()
 	}
 
-	vm, err := vz.NewVirtualMachine(&config)
-	if err != nil {
-		log.Fatal(err)
-	}
+	// Publish the outcome before the PID file is removed, so that anyone
+	// noticing the launcher is gone finds out why.
+	defer func() {
+		l.publisher.publishExit(reason, err)
+	}()
 
-	getStateName := func(state vzog.VirtualMachineState) string {
-		stateName := "Unknown"
-		switch state {
-		case vzog.VirtualMachineStateError:
-			stateName = "Error"
-		case vzog.VirtualMachineStatePaused:
-			stateName = "Paused"
-		case vzog.VirtualMachineStatePausing:
-			stateName = "Pausing"
-		case vzog.VirtualMachineStateResuming:
-			stateName = "Resuming"
-		case vzog.VirtualMachineStateRunning:
-			stateName = "Running"
-		case vzog.VirtualMachineStateStarting:
-			stateName = "Starting"
-		case vzog.VirtualMachineStateStopped:
-			stateName = "Stopped"
+	if l.metricsAddress != "" {
+		listener, err := listenMetrics(l.metricsAddress)
+		if err != nil {
+			return vz.ExitReasonStartError, err
 		}
-		return stateName
+
+		l.publisher.metrics = newMetrics(l.config)
+		mux := http.NewServeMux()
+		mux.Handle(metricsPath, l.publisher.metrics)
+		metricsServer := &http.Server{Handler: mux}
+		go func() {
+			if err := metricsServer.Serve(listener); err != http.ErrServerClosed {
+				log.Println("Metrics server failed:", err)
+			}
+		}()
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), controlShutdownTimeout)
+			defer cancel()
+			metricsServer.Shutdown(ctx)
+		}()
 	}
 
-	printCurrentState := func() {
-		state := vm.State()
-		log.Printf("VM state: %v", getStateName(state))
+	// Only the first virtual machine gets the first boot command line, not
+	// those replacing it below.
+	vm, err := vz.NewVirtualMachine(l.backend, l.config.FirstBoot())
+	if err != nil {
+		return vz.ExitReasonStartError, err
 	}
+	m := newMachine(vm, l.config, l.publisher)
 
-	go func() {
-		printCurrentState()
-		for {
-			<-vm.StateChangedNotify()
-			printCurrentState()
+	var server *control.Server
+	if l.controlSocketName != "" {
+		listener, err := control.Listen(l.controlSocketName)
+		if err != nil {
+			vm.Close()
+			return vz.ExitReasonStartError, err
 		}
-	}()
+
+		server = control.NewServer(m)
+		go func() {
+			if err := server.Serve(listener); err != nil {
+				log.Println("Control server failed:", err)
+			}
+		}()
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), controlShutdownTimeout)
+			defer cancel()
+			server.Shutdown(ctx)
+		}()
+	}
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGTERM, os.Kill, os.Interrupt)
 
-	var startError error
-	startHandler := func(err error) {
-		startError = err
+	// A rebooting guest stops its virtual machine, which is replaced by a
+	// new one from the same configuration, behind the same PID and
+	// control socket, as is one stopped by the watchdog. The restart
+	// policy may do the same for machines that stopped otherwise, except
+	// if the first one failed to start, which is reported to whoever
+	// started the launcher instead.
+	restarter := newRestarter(l.config.RestartPolicy)
+	for first := true; ; first = false {
+		started := time.Now()
+		reason, err := l.boot(vm, m, signals)
+		switch reason {
+		case vz.ExitReasonGuestReboot:
+			log.Println("Guest rebooted, starting a new virtual machine")
+		case vz.ExitReasonWatchdog:
+			log.Println("Unhealthy guest stopped, starting a new virtual machine")
+		default:
+			if first && reason == vz.ExitReasonStartError {
+				return reason, err
+			}
+
+			backoff, restart, giveUpErr := restarter.next(reason, started, time.Now())
+			if giveUpErr != nil {
+				log.Println(giveUpErr)
+				if err == nil {
+					return reason, giveUpErr
+				}
+				return reason, errors.Wrap(err, giveUpErr.Error())
+			}
+			if !restart {
+				return reason, err
+			}
+
+			log.Printf("Virtual machine stopped (%s), restarting in %s (attempt %d)", reason, backoff, restarter.attempts)
+			m.setRestarting()
+			l.publisher.publishRestart(reason, err, restarter.attempts, backoff)
+
+			select {
+			case <-time.After(backoff):
+			case <-m.killed:
+				log.Println("Stop requested, not restarting")
+				return vz.ExitReasonHostRequest, nil
+			case sig := <-signals:
+				log.Println("Received signal, not restarting:", sig)
+				return vz.ExitReasonHostSignal, nil
+			}
+		}
+
+		l.publisher.metrics.recordRestart(reason)
+		vm, err = vz.NewVirtualMachine(l.backend, l.config)
+		if err != nil {
+			return vz.ExitReasonStartError, err
+		}
+		m = newMachine(vm, l.config, l.publisher)
+		if server != nil {
+			server.SetMachine(m)
+		}
+	}
+}
+
+// boot starts vm, exposed as m, and waits for it to stop, returning why it
+// stopped. vm is closed once it stopped.
+func (l *launcher) boot(vm *vz.VirtualMachine, m *machine, signals <-chan os.Signal) (vz.ExitReason, error) {
+	defer vm.Close()
+
+	go m.watchState()
+	defer m.stopWatching()
+
+	if len(l.config.PortForwards) > 0 {
+		stopForwarding, err := startForwarding(vm, l.config.PortForwards)
+		if err != nil {
+			return vz.ExitReasonStartError, err
+		}
+		defer stopForwarding()
 	}
 
-	vm.Start(startHandler)
-	if startError != nil {
-		log.Fatal(errors.Wrap(startError, "Failed to start VM"))
+	var unhealthy <-chan struct{}
+	if l.config.Watchdog != nil {
+		w, err := startWatchdog(vm, time.Duration(l.config.Watchdog.Timeout)*time.Second, l.publisher)
+		if err != nil {
+			return vz.ExitReasonStartError, err
+		}
+		defer w.close()
+		m.setWatchdog(w)
+		unhealthy = w.unhealthy
 	}
 
-	sig := <-signals
-	log.Println("Received signal:", sig)
+	if err := vm.Start(); err != nil {
+		return vz.ExitReasonStartError, errors.Wrap(err, "Failed to start VM")
+	}
+	l.ready.report(nil)
 
-	if stopped, err := vm.RequestStop(); !stopped || err != nil {
-		log.Fatal(errors.Wrap(err, "Failed to gracefully stop virtual machine"))
+	for {
+		select {
+		case sig := <-signals:
+			log.Println("Received signal:", sig)
+
+			if m.isStopping() {
+				log.Println("Stop already requested, forcing stop")
+				return vz.ExitReasonHostSignal, nil
+			}
+
+			if err := m.requestStop(vz.ExitReasonHostSignal); err != nil {
+				return vz.ExitReasonHostSignal, errors.Wrap(err, "Failed to gracefully stop virtual machine")
+			}
+		case <-m.killed:
+			log.Println("Forced stop requested")
+			return vz.ExitReasonHostRequest, nil
+		case <-unhealthy:
+			if !l.config.Watchdog.Restart {
+				continue
+			}
+			log.Println("Stopping unhealthy guest")
+			if err := m.stopUnhealthy(); err != nil {
+				log.Println(err)
+			}
+		case <-m.stopped:
+			reason := m.exitReason()
+			log.Println("Virtual machine stopped:", reason)
+			if reason == vz.ExitReasonVMError {
+				return reason, errors.New("Virtual machine entered the error state")
+			}
+			return reason, nil
+		}
 	}
 }
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/agent"
+	"github.com/brholstein/docker-machine-driver-vz/internal/control"
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz/vztest"
+	"github.com/pkg/errors"
+)
+
+type launcherResult struct {
+	reason vz.ExitReason
+	err    error
+}
+
+func writeFile(t *testing.T, path string) string {
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// startLauncher runs a launcher for a minimal machine, modified by configure
+// if it is not nil.
+func startLauncher(t *testing.T, backend vz.Backend, configure func(*launcher)) (*launcher, <-chan launcherResult) {
+	dir := t.TempDir()
+	l := &launcher{
+		backend: backend,
+		config: &vz.VirtualMachineConfig{
+			Kernel: writeFile(t, filepath.Join(dir, "bzImage")),
+			CPUs:   1,
+			Memory: 1024,
+			Disks: []vz.VirtualMachineDiskConfig{
+				{Path: writeFile(t, filepath.Join(dir, "disk.img"))},
+			},
+		},
+		pidFileName:       filepath.Join(dir, "vz.pid"),
+		controlSocketName: filepath.Join(dir, control.SocketFileName),
+		publisher:         newStatePublisher(filepath.Join(dir, vz.StateFileName)),
+	}
+	if configure != nil {
+		configure(l)
+	}
+
+	result := make(chan launcherResult, 1)
+	go func() {
+		reason, err := l.run()
+		result <- launcherResult{reason: reason, err: err}
+	}()
+
+	return l, result
+}
+
+func waitForState(t *testing.T, client *control.Client, want string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := client.State()
+		if err == nil && got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Wanted state %q but got %q (%v)", want, got, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitForResult(t *testing.T, result <-chan launcherResult) launcherResult {
+	select {
+	case r := <-result:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("Launcher did not exit")
+	}
+	return launcherResult{}
+}
+
+func TestLauncher_RequestStop(t *testing.T) {
+	backend := vztest.NewBackend()
+	l, result := startLauncher(t, backend, nil)
+	client := control.NewClient(l.controlSocketName)
+
+	waitForState(t, client, "Running")
+
+	machines := backend.Machines()
+	if len(machines) != 1 {
+		t.Fatalf("Wanted 1 machine but got %d", len(machines))
+	}
+	spec := machines[0].Spec
+	if spec.CPUs != 1 || spec.MemorySize != 1024*1024*1024 {
+		t.Errorf("Unexpected CPUs %d and memory %d", spec.CPUs, spec.MemorySize)
+	}
+	if len(spec.StorageDevices) != 1 || spec.StorageDevices[0].Path != l.config.Disks[0].Path {
+		t.Errorf("Unexpected storage devices %+v", spec.StorageDevices)
+	}
+
+	if err := client.Pause(); err != nil {
+		t.Fatalf("Unexpected error pausing: %s", err)
+	}
+	waitForState(t, client, "Paused")
+	if err := client.Resume(); err != nil {
+		t.Fatalf("Unexpected error resuming: %s", err)
+	}
+	waitForState(t, client, "Running")
+
+	if err := client.RequestStop(); err != nil {
+		t.Fatalf("Unexpected error requesting stop: %s", err)
+	}
+
+	r := waitForResult(t, result)
+	if r.err != nil || r.reason != vz.ExitReasonHostRequest {
+		t.Errorf("Wanted exit reason %q but got %q (%v)", vz.ExitReasonHostRequest, r.reason, r.err)
+	}
+
+	status, err := vz.ReadStateFile(l.publisher.path)
+	if err != nil {
+		t.Fatalf("Unexpected error reading state file: %s", err)
+	}
+	if !status.Exited || status.State != "Stopped" || status.LastExit == nil || status.LastExit.Reason != vz.ExitReasonHostRequest {
+		t.Errorf("Unexpected state file contents %+v", status)
+	}
+}
+
+func TestLauncher_GuestStop(t *testing.T) {
+	tests := []struct {
+		msg          string
+		state        vz.State
+		wantReason   vz.ExitReason
+		wantExitCode int
+	}{
+		{
+			msg:          "guest powers off",
+			state:        vz.StateStopped,
+			wantReason:   vz.ExitReasonGuestShutdown,
+			wantExitCode: exitCodeStopped,
+		}, {
+			msg:          "virtual machine error",
+			state:        vz.StateError,
+			wantReason:   vz.ExitReasonVMError,
+			wantExitCode: exitCodeVMError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			backend := vztest.NewBackend()
+			l, result := startLauncher(t, backend, nil)
+			waitForState(t, control.NewClient(l.controlSocketName), "Running")
+
+			backend.Machines()[0].SetState(tt.state)
+
+			r := waitForResult(t, result)
+			if r.reason != tt.wantReason {
+				t.Errorf("Wanted exit reason %q but got %q (%v)", tt.wantReason, r.reason, r.err)
+			}
+			if code := exitCode(r.reason, r.err); code != tt.wantExitCode {
+				t.Errorf("Wanted exit code %d but got %d", tt.wantExitCode, code)
+			}
+
+			for _, path := range []string{l.pidFileName, l.controlSocketName} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("Wanted %s to be removed but got %v", path, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLauncher_Reboot(t *testing.T) {
+	backend := vztest.NewBackend()
+	l, result := startLauncher(t, backend, func(l *launcher) {
+		l.config.SerialPorts = []vz.VirtualMachineSerialPort{
+			{Type: vz.SerialPortUnix, Path: filepath.Join(filepath.Dir(l.pidFileName), "console.sock")},
+		}
+		l.config.CmdLine = "console=hvc0"
+		l.config.FirstBootCmdLine = "console=hvc0 ignition.firstboot"
+	})
+	client := control.NewClient(l.controlSocketName)
+	waitForState(t, client, "Running")
+
+	first := backend.Machines()[0]
+	if cmdLine := first.Spec.BootLoader.CmdLine; cmdLine != l.config.FirstBootCmdLine {
+		t.Errorf("Wanted the first boot command line but got %q", cmdLine)
+	}
+	if _, err := first.Spec.SerialPorts[0].Write.WriteString("reboot: Restarting system\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	first.SetState(vz.StateStopped)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(backend.Machines()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Launcher did not start a new virtual machine")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitForState(t, client, "Running")
+	if cmdLine := backend.Machines()[1].Spec.BootLoader.CmdLine; cmdLine != l.config.CmdLine {
+		t.Errorf("Wanted the rebooted guest to get command line %q but got %q", l.config.CmdLine, cmdLine)
+	}
+
+	status, err := vz.ReadStateFile(l.publisher.path)
+	if err != nil {
+		t.Fatalf("Unexpected error reading state file: %s", err)
+	}
+	if status.Exited || status.LastExit == nil || status.LastExit.Reason != vz.ExitReasonGuestReboot {
+		t.Errorf("Unexpected state file contents %+v", status)
+	}
+
+	if err := client.RequestStop(); err != nil {
+		t.Fatalf("Unexpected error requesting stop: %s", err)
+	}
+	r := waitForResult(t, result)
+	if r.err != nil || r.reason != vz.ExitReasonHostRequest {
+		t.Errorf("Wanted exit reason %q but got %q (%v)", vz.ExitReasonHostRequest, r.reason, r.err)
+	}
+}
+
+func TestLauncher_RestartPolicy(t *testing.T) {
+	backend := vztest.NewBackend()
+	l, result := startLauncher(t, backend, func(l *launcher) {
+		l.config.RestartPolicy = &vz.VirtualMachineRestartPolicy{Mode: vz.RestartOnFailure, MaxRetries: 1}
+	})
+	client := control.NewClient(l.controlSocketName)
+	waitForState(t, client, "Running")
+
+	backend.Machines()[0].SetState(vz.StateError)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(backend.Machines()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Launcher did not restart the virtual machine")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitForState(t, client, "Running")
+
+	backend.Machines()[1].SetState(vz.StateError)
+
+	r := waitForResult(t, result)
+	if r.err == nil || r.reason != vz.ExitReasonVMError {
+		t.Errorf("Wanted exit reason %q with an error but got %q (%v)", vz.ExitReasonVMError, r.reason, r.err)
+	}
+
+	status, err := vz.ReadStateFile(l.publisher.path)
+	if err != nil {
+		t.Fatalf("Unexpected error reading state file: %s", err)
+	}
+	if len(status.Restarts) != 1 || status.Restarts[0].Attempt != 1 || status.Restarts[0].Exit.Reason != vz.ExitReasonVMError {
+		t.Errorf("Unexpected restarts %+v", status.Restarts)
+	}
+}
+
+func TestLauncher_PowerOffAfterFailedStopRequest(t *testing.T) {
+	backend := vztest.NewBackend()
+	backend.NoStopRequests = true
+	l, result := startLauncher(t, backend, func(l *launcher) {
+		l.config.RestartPolicy = &vz.VirtualMachineRestartPolicy{Mode: vz.RestartAlways}
+	})
+	client := control.NewClient(l.controlSocketName)
+	waitForState(t, client, "Running")
+
+	if err := client.RequestStop(); err == nil {
+		t.Fatal("Wanted the stop request to fail")
+	}
+	// The driver powers the guest off over SSH instead.
+	backend.Machines()[0].SetState(vz.StateStopped)
+
+	r := waitForResult(t, result)
+	if r.err != nil || r.reason != vz.ExitReasonHostRequest {
+		t.Errorf("Wanted exit reason %q but got %q (%v)", vz.ExitReasonHostRequest, r.reason, r.err)
+	}
+	if n := len(backend.Machines()); n != 1 {
+		t.Errorf("Wanted the virtual machine not to be restarted but got %d machines", n)
+	}
+}
+
+func TestLauncher_Watchdog(t *testing.T) {
+	backend := vztest.NewBackend()
+	l, result := startLauncher(t, backend, func(l *launcher) {
+		l.config.Watchdog = &vz.VirtualMachineWatchdog{Timeout: 1, Restart: true}
+	})
+	client := control.NewClient(l.controlSocketName)
+	waitForState(t, client, "Running")
+
+	waitForHealth := func(want vz.Health) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got, err := client.Health()
+			if err == nil && got.Health == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Wanted health %q but got %+v (%v)", want, got, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForHealth(vz.HealthWaiting)
+
+	guest, err := backend.Machines()[0].DialHost(agent.HeartbeatPort)
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to the watchdog: %s", err)
+	}
+	defer guest.Close()
+	if _, err := io.WriteString(guest, "HEARTBEAT\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitForHealth(vz.HealthHealthy)
+
+	// Without further heartbeats, the guest is stopped and started again.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(backend.Machines()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Launcher did not restart the unhealthy virtual machine")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitForState(t, client, "Running")
+	waitForHealth(vz.HealthWaiting)
+
+	status, err := vz.ReadStateFile(l.publisher.path)
+	if err != nil {
+		t.Fatalf("Unexpected error reading state file: %s", err)
+	}
+	if status.LastExit == nil || status.LastExit.Reason != vz.ExitReasonWatchdog {
+		t.Errorf("Unexpected state file contents %+v", status)
+	}
+
+	if err := client.RequestStop(); err != nil {
+		t.Fatalf("Unexpected error requesting stop: %s", err)
+	}
+	waitForResult(t, result)
+}
+
+func TestLauncher_StartError(t *testing.T) {
+	backend := vztest.NewBackend()
+	backend.StartError = errors.New("boom")
+	l, result := startLauncher(t, backend, nil)
+
+	r := waitForResult(t, result)
+	if r.err == nil || r.reason != vz.ExitReasonStartError {
+		t.Errorf("Wanted exit reason %q but got %q (%v)", vz.ExitReasonStartError, r.reason, r.err)
+	}
+
+	status, err := vz.ReadStateFile(l.publisher.path)
+	if err != nil {
+		t.Fatalf("Unexpected error reading state file: %s", err)
+	}
+	if status.LastExit == nil || status.LastExit.Error != "Failed to start VM: boom" {
+		t.Errorf("Unexpected state file contents %+v", status)
+	}
+}
+
+func TestLauncher_Readiness(t *testing.T) {
+	tests := []struct {
+		msg        string
+		startError error
+		wantErr    string
+	}{
+		{
+			msg: "started",
+		}, {
+			msg:        "start error",
+			startError: errors.New("boom"),
+			wantErr:    "Failed to start VM: boom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ready, readyWrite, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ready.Close()
+
+			backend := vztest.NewBackend()
+			backend.StartError = tt.startError
+			l, result := startLauncher(t, backend, func(l *launcher) {
+				l.ready = &readiness{w: readyWrite}
+			})
+
+			err = vz.ReadReadiness(ready)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unexpected readiness error %q", err)
+				}
+				control.NewClient(l.controlSocketName).ForceStop()
+			} else if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Wanted readiness error %q but got %v", tt.wantErr, err)
+			}
+			waitForResult(t, result)
+		})
+	}
+}
+
+func TestLauncher_MemoryTarget(t *testing.T) {
+	backend := vztest.NewBackend()
+	l, result := startLauncher(t, backend, func(l *launcher) {
+		l.config.MemoryBalloon = true
+	})
+	client := control.NewClient(l.controlSocketName)
+
+	waitForState(t, client, "Running")
+
+	tests := []struct {
+		msg     string
+		target  uint
+		want    uint
+		wantErr bool
+	}{
+		{msg: "shrink", target: 512, want: 512},
+		{msg: "grow to maximum", target: 1024, want: 1024},
+		{msg: "beyond maximum", target: 2048, want: 1024, wantErr: true},
+		{msg: "below minimum", target: 64, want: 1024, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			_, err := client.SetMemoryTarget(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Wanted error %t but got %v", tt.wantErr, err)
+			}
+
+			memory, err := client.Memory()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if memory.Target != tt.want || memory.Maximum != 1024 {
+				t.Errorf("Wanted target %d of 1024 MiB but got %+v", tt.want, memory)
+			}
+		})
+	}
+
+	if err := client.RequestStop(); err != nil {
+		t.Fatalf("Unexpected error requesting stop: %s", err)
+	}
+	waitForResult(t, result)
+}
+
+func TestLauncher_PortForward(t *testing.T) {
+	// The guest service echoes what it receives.
+	service, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	go func() {
+		for {
+			conn, err := service.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	backend := vztest.NewBackend()
+	backend.VsockHandler = func(port uint32, conn net.Conn) {
+		if port != agent.Port {
+			conn.Close()
+			return
+		}
+		agent.ServeConn(conn, func(port uint16) (net.Conn, error) {
+			return net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		})
+	}
+
+	socket := filepath.Join(t.TempDir(), "service.sock")
+	l, result := startLauncher(t, backend, func(l *launcher) {
+		l.config.PortForwards = []vz.VirtualMachinePortForward{
+			{Network: "unix", Address: socket, GuestPort: uint16(service.Addr().(*net.TCPAddr).Port)},
+		}
+	})
+	client := control.NewClient(l.controlSocketName)
+
+	waitForState(t, client, "Running")
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to forwarded port: %s", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("Unexpected error reading from forwarded port: %s", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("Wanted %q from the guest service but got %q", "hello", got)
+	}
+	conn.Close()
+
+	if err := client.RequestStop(); err != nil {
+		t.Fatalf("Unexpected error requesting stop: %s", err)
+	}
+	waitForResult(t, result)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// metricsPath is where the metrics listener serves the metrics.
+const metricsPath = "/metrics"
+
+// metricStates are the states the state metrics have a series for.
+var metricStates = []string{
+	vz.StateStopped.String(),
+	vz.StateStarting.String(),
+	vz.StateRunning.String(),
+	vz.StatePausing.String(),
+	vz.StatePaused.String(),
+	vz.StateResuming.String(),
+	vz.StateError.String(),
+	restartingState,
+}
+
+// metrics collects what the launcher exports in the Prometheus text format.
+// Its methods do nothing on a nil *metrics, for launchers without a metrics
+// listener.
+type metrics struct {
+	config    *vz.VirtualMachineConfig
+	startTime time.Time
+
+	mu          sync.Mutex
+	state       string
+	bootTime    time.Time
+	transitions map[string]uint64
+	restarts    map[vz.ExitReason]uint64
+}
+
+func newMetrics(config *vz.VirtualMachineConfig) *metrics {
+	return &metrics{
+		config:      config,
+		startTime:   time.Now(),
+		state:       vz.StateStopped.String(),
+		transitions: map[string]uint64{},
+		restarts:    map[vz.ExitReason]uint64{},
+	}
+}
+
+// recordState records that the virtual machine entered state.
+func (m *metrics) recordState(state string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if state == m.state {
+		return
+	}
+	m.state = state
+	m.transitions[state]++
+
+	// Uptime counts from the start of the current virtual machine, paused
+	// or not.
+	switch state {
+	case vz.StateRunning.String():
+		if m.bootTime.IsZero() {
+			m.bootTime = time.Now()
+		}
+	case vz.StateStopped.String(), vz.StateError.String(), restartingState:
+		m.bootTime = time.Time{}
+	}
+}
+
+// recordRestart records that the launcher started a new virtual machine
+// after the previous one stopped because of reason.
+func (m *metrics) recordRestart(reason vz.ExitReason) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.restarts[reason]++
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// write writes every metric to w in the Prometheus text format.
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	state, bootTime := m.state, m.bootTime
+	transitions := make(map[string]uint64, len(m.transitions))
+	for name, count := range m.transitions {
+		transitions[name] = count
+	}
+	restarts := make(map[vz.ExitReason]uint64, len(m.restarts))
+	for reason, count := range m.restarts {
+		restarts[reason] = count
+	}
+	m.mu.Unlock()
+
+	writeHeader(w, "vz_vm_state", "gauge", "Whether the virtual machine is in the state.")
+	for _, name := range metricStates {
+		value := 0
+		if name == state {
+			value = 1
+		}
+		fmt.Fprintf(w, "vz_vm_state{state=%q} %d\n", name, value)
+	}
+
+	var uptime float64
+	if !bootTime.IsZero() {
+		uptime = time.Since(bootTime).Seconds()
+	}
+	writeHeader(w, "vz_vm_uptime_seconds", "gauge", "Seconds since the virtual machine started, 0 if it is not running.")
+	fmt.Fprintf(w, "vz_vm_uptime_seconds %g\n", uptime)
+
+	writeHeader(w, "vz_vm_state_transitions_total", "counter", "Number of times the virtual machine entered the state.")
+	for _, name := range metricStates {
+		fmt.Fprintf(w, "vz_vm_state_transitions_total{state=%q} %d\n", name, transitions[name])
+	}
+
+	writeHeader(w, "vz_vm_restarts_total", "counter", "Number of times the launcher started a new virtual machine, by why the previous one stopped.")
+	reasons := make([]string, 0, len(restarts))
+	for reason := range restarts {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "vz_vm_restarts_total{reason=%q} %d\n", reason, restarts[vz.ExitReason(reason)])
+	}
+
+	writeHeader(w, "vz_vm_cpus", "gauge", "Number of CPUs configured for the virtual machine.")
+	fmt.Fprintf(w, "vz_vm_cpus %d\n", m.config.CPUs)
+	writeHeader(w, "vz_vm_memory_bytes", "gauge", "Memory configured for the virtual machine in bytes.")
+	fmt.Fprintf(w, "vz_vm_memory_bytes %d\n", uint64(m.config.Memory)*1024*1024)
+
+	var usage unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_SELF, &usage); err == nil {
+		cpu := time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+		writeHeader(w, "process_cpu_seconds_total", "counter", "Total user and system CPU time spent by the launcher in seconds.")
+		fmt.Fprintf(w, "process_cpu_seconds_total %g\n", cpu.Seconds())
+	}
+	if rss, err := residentMemory(); err == nil {
+		writeHeader(w, "process_resident_memory_bytes", "gauge", "Resident memory size of the launcher in bytes.")
+		fmt.Fprintf(w, "process_resident_memory_bytes %d\n", rss)
+	}
+	writeHeader(w, "process_start_time_seconds", "gauge", "Start time of the launcher since unix epoch in seconds.")
+	fmt.Fprintf(w, "process_start_time_seconds %d\n", m.startTime.Unix())
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// listenMetrics listens on address, either "unix:<path>" for a Unix socket
+// only accessible by its owner or "[<host>:]<port>" for TCP, on 127.0.0.1 if
+// no host is given.
+func listenMetrics(address string) (net.Listener, error) {
+	if path := strings.TrimPrefix(address, "unix:"); path != address {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "Failed to remove stale metrics socket")
+		}
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to listen on metrics socket")
+		}
+		if err := os.Chmod(path, 0o600); err != nil {
+			listener.Close()
+			return nil, errors.Wrap(err, "Failed to set metrics socket permissions")
+		}
+		return listener, nil
+	}
+
+	if !strings.Contains(address, ":") {
+		address = net.JoinHostPort("127.0.0.1", address)
+	}
+	listener, err := net.Listen("tcp", address)
+	return listener, errors.Wrap(err, "Failed to listen for metrics")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+)
+
+func TestMetrics_write(t *testing.T) {
+	m := newMetrics(&vz.VirtualMachineConfig{CPUs: 2, Memory: 1024})
+	for _, state := range []string{"Starting", "Running", "Stopped", restartingState, "Starting", "Running"} {
+		m.recordState(state)
+	}
+	m.recordRestart(vz.ExitReasonGuestReboot)
+
+	var buf bytes.Buffer
+	m.write(&buf)
+	got := buf.String()
+
+	for _, want := range []string{
+		`vz_vm_state{state="Running"} 1`,
+		`vz_vm_state{state="Stopped"} 0`,
+		`vz_vm_state_transitions_total{state="Running"} 2`,
+		`vz_vm_state_transitions_total{state="Error"} 0`,
+		`vz_vm_restarts_total{reason="guest-reboot"} 1`,
+		"vz_vm_cpus 2",
+		"vz_vm_memory_bytes 1073741824",
+		"# TYPE process_cpu_seconds_total counter",
+		"process_resident_memory_bytes ",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Wanted metrics to contain %q but got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "vz_vm_uptime_seconds 0\n") {
+		t.Errorf("Wanted a running virtual machine to have an uptime but got:\n%s", got)
+	}
+}
+
+func TestListenMetrics_Unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+	listener, err := listenMetrics("unix:" + path)
+	if err != nil {
+		t.Fatalf("Unexpected error listening: %s", err)
+	}
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Wanted a socket only accessible by its owner but got mode %#o", perm)
+	}
+}
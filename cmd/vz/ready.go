@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+)
+
+// readiness reports once to the process that started the launcher whether
+// the virtual machine started. A nil readiness reports nothing.
+type readiness struct {
+	w    io.WriteCloser
+	once sync.Once
+}
+
+// openReadiness returns a readiness writing to the inherited file
+// descriptor fd, or nil if fd is negative.
+func openReadiness(fd int) *readiness {
+	if fd < 0 {
+		return nil
+	}
+	return &readiness{w: os.NewFile(uintptr(fd), "ready")}
+}
+
+func (r *readiness) report(err error) {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		if err := vz.WriteReadiness(r.w, err); err != nil {
+			log.Println("Failed to report readiness:", err)
+		}
+		r.w.Close()
+	})
+}
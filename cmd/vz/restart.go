@@ -0,0 +1,73 @@
+package main
+
+import (
+	"time"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+	"github.com/pkg/errors"
+)
+
+// restarter applies a restart policy to the virtual machines of a launcher.
+type restarter struct {
+	policy vz.VirtualMachineRestartPolicy
+
+	// attempts are the restarts since a virtual machine last ran for the
+	// crash loop window.
+	attempts uint
+	// recent are the times of the restarts within the crash loop window.
+	recent []time.Time
+}
+
+// newRestarter returns a restarter for policy, which never restarts if
+// policy is nil.
+func newRestarter(policy *vz.VirtualMachineRestartPolicy) *restarter {
+	if policy == nil {
+		return &restarter{policy: vz.VirtualMachineRestartPolicy{Mode: vz.RestartNo}}
+	}
+	return &restarter{policy: policy.WithDefaults()}
+}
+
+// next decides whether a virtual machine that started at started and
+// stopped at now because of reason is restarted, returning how long to wait
+// before restarting it. It returns an error if the policy would restart it
+// but gives up, because of the retry limit or a crash loop.
+func (r *restarter) next(reason vz.ExitReason, started, now time.Time) (backoff time.Duration, restart bool, err error) {
+	if !r.policy.Restarts(reason) {
+		return 0, false, nil
+	}
+
+	// A virtual machine that ran for the crash loop window recovered, so
+	// the retry limit applies to the restarts after it anew.
+	window := time.Duration(r.policy.CrashLoopWindow) * time.Second
+	if now.Sub(started) >= window {
+		r.attempts = 0
+	}
+
+	if r.policy.MaxRetries > 0 && r.attempts >= r.policy.MaxRetries {
+		return 0, false, errors.Errorf("Not restarting, reached the limit of %d restarts", r.policy.MaxRetries)
+	}
+
+	recent := r.recent[:0]
+	for _, t := range r.recent {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	r.recent = recent
+	if uint(len(r.recent)) >= r.policy.CrashLoopRestarts {
+		return 0, false, errors.Errorf("Not restarting, crash loop of %d restarts within %s", len(r.recent), window)
+	}
+
+	backoff = time.Duration(r.policy.Backoff) * time.Second
+	maxBackoff := time.Duration(r.policy.MaxBackoff) * time.Second
+	for i := 0; i < len(r.recent) && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	r.attempts++
+	r.recent = append(r.recent, now)
+	return backoff, true, nil
+}
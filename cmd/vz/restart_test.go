@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+)
+
+func TestRestarter_next(t *testing.T) {
+	type stop struct {
+		reason vz.ExitReason
+		// after is the time since the previous stop, and uptime how long
+		// the virtual machine ran for within it.
+		after       time.Duration
+		uptime      time.Duration
+		wantBackoff time.Duration
+		wantRestart bool
+		wantErr     bool
+	}
+
+	tests := []struct {
+		msg    string
+		policy *vz.VirtualMachineRestartPolicy
+		stops  []stop
+	}{
+		{
+			msg: "no policy",
+			stops: []stop{
+				{reason: vz.ExitReasonVMError},
+			},
+		}, {
+			msg:    "on failure",
+			policy: &vz.VirtualMachineRestartPolicy{Mode: vz.RestartOnFailure},
+			stops: []stop{
+				{reason: vz.ExitReasonGuestShutdown},
+				{reason: vz.ExitReasonVMError, wantBackoff: time.Second, wantRestart: true},
+				{reason: vz.ExitReasonHostRequest},
+			},
+		}, {
+			msg:    "always",
+			policy: &vz.VirtualMachineRestartPolicy{Mode: vz.RestartAlways},
+			stops: []stop{
+				{reason: vz.ExitReasonGuestShutdown, wantBackoff: time.Second, wantRestart: true},
+				{reason: vz.ExitReasonHostSignal},
+			},
+		}, {
+			msg:    "backoff doubles up to the maximum and resets",
+			policy: &vz.VirtualMachineRestartPolicy{Mode: vz.RestartOnFailure, Backoff: 2, MaxBackoff: 5, CrashLoopRestarts: 10},
+			stops: []stop{
+				{reason: vz.ExitReasonVMError, wantBackoff: 2 * time.Second, wantRestart: true},
+				{reason: vz.ExitReasonVMError, after: time.Minute, wantBackoff: 4 * time.Second, wantRestart: true},
+				{reason: vz.ExitReasonVMError, after: time.Minute, wantBackoff: 5 * time.Second, wantRestart: true},
+				{reason: vz.ExitReasonVMError, after: time.Hour, wantBackoff: 2 * time.Second, wantRestart: true},
+			},
+		}, {
+			msg:    "max retries",
+			policy: &vz.VirtualMachineRestartPolicy{Mode: vz.RestartOnFailure, MaxRetries: 1},
+			stops: []stop{
+				{reason: vz.ExitReasonVMError, wantBackoff: time.Second, wantRestart: true},
+				{reason: vz.ExitReasonVMError, after: time.Hour, wantErr: true},
+			},
+		}, {
+			msg:    "max retries reset by a boot lasting the crash loop window",
+			policy: &vz.VirtualMachineRestartPolicy{Mode: vz.RestartOnFailure, MaxRetries: 1, CrashLoopWindow: 60},
+			stops: []stop{
+				{reason: vz.ExitReasonVMError, wantBackoff: time.Second, wantRestart: true},
+				{reason: vz.ExitReasonVMError, after: time.Hour, uptime: time.Hour, wantBackoff: time.Second, wantRestart: true},
+				{reason: vz.ExitReasonVMError, after: 2 * time.Minute, uptime: 59 * time.Second, wantErr: true},
+			},
+		}, {
+			msg:    "crash loop",
+			policy: &vz.VirtualMachineRestartPolicy{Mode: vz.RestartOnFailure, CrashLoopRestarts: 2, CrashLoopWindow: 60},
+			stops: []stop{
+				{reason: vz.ExitReasonVMError, wantBackoff: time.Second, wantRestart: true},
+				{reason: vz.ExitReasonVMError, after: 10 * time.Second, wantBackoff: 2 * time.Second, wantRestart: true},
+				{reason: vz.ExitReasonVMError, after: 10 * time.Second, wantErr: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			r := newRestarter(tt.policy)
+			now := time.Now()
+			for i, s := range tt.stops {
+				now = now.Add(s.after)
+				backoff, restart, err := r.next(s.reason, now.Add(-s.uptime), now)
+				if (err != nil) != s.wantErr {
+					t.Fatalf("Stop %d: wanted error %t but got %v", i, s.wantErr, err)
+				}
+				if restart != s.wantRestart || backoff != s.wantBackoff {
+					t.Errorf("Stop %d: wanted restart %t after %s but got %t after %s", i, s.wantRestart, s.wantBackoff, restart, backoff)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+/*
+#include <mach/mach.h>
+
+static kern_return_t residentSize(mach_vm_size_t *size)
+{
+	mach_task_basic_info_data_t info;
+	mach_msg_type_number_t count = MACH_TASK_BASIC_INFO_COUNT;
+	kern_return_t ret = task_info(mach_task_self(), MACH_TASK_BASIC_INFO, (task_info_t)&info, &count);
+	if (ret == KERN_SUCCESS) {
+		*size = info.resident_size;
+	}
+	return ret;
+}
+*/
+import "C"
+
+import "github.com/pkg/errors"
+
+// residentMemory returns the resident memory size of the launcher.
+func residentMemory() (uint64, error) {
+	var size C.mach_vm_size_t
+	if ret := C.residentSize(&size); ret != C.KERN_SUCCESS {
+		return 0, errors.Errorf("Failed to get task info: %d", int(ret))
+	}
+	return uint64(size), nil
+}
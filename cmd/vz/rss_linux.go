@@ -0,0 +1,25 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// residentMemory returns the resident memory size of the launcher.
+func residentMemory() (uint64, error) {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+
+	var size, resident uint64
+	if _, err := fmt.Sscan(string(statm), &size, &resident); err != nil {
+		return 0, errors.Wrap(err, "Failed to parse /proc/self/statm")
+	}
+	return resident * uint64(os.Getpagesize()), nil
+}
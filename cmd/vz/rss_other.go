@@ -0,0 +1,12 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package main
+
+import "github.com/pkg/errors"
+
+// residentMemory returns the resident memory size of the launcher, which
+// is only known on macOS and Linux.
+func residentMemory() (uint64, error) {
+	return 0, errors.New("Resident memory size is not supported on this system")
+}
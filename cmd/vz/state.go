@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+)
+
+// statePublisher writes the state of the virtual machine to the state file,
+// if one was requested.
+type statePublisher struct {
+	path string
+
+	mu       sync.Mutex
+	state    string
+	lastExit *vz.Exit
+	restarts []vz.Restart
+	health   vz.Health
+
+	// metrics, if not nil, also records every state.
+	metrics *metrics
+}
+
+// maxRestartHistory is how many restarts are kept in the state file.
+const maxRestartHistory = 10
+
+// newStatePublisher returns a statePublisher writing to path, carrying over
+// the last exit recorded there by a previous launcher.
+func newStatePublisher(path string) *statePublisher {
+	p := &statePublisher{path: path}
+
+	if path != "" {
+		if status, err := vz.ReadStateFile(path); err == nil {
+			p.lastExit = status.LastExit
+		} else if !os.IsNotExist(err) {
+			log.Println("Ignoring previous state file:", err)
+		}
+	}
+
+	return p
+}
+
+// publishState records the current state of the virtual machine.
+func (p *statePublisher) publishState(state string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.state = state
+	p.metrics.recordState(state)
+	p.write(false)
+}
+
+// publishHealth records the health of the guest.
+func (p *statePublisher) publishHealth(health vz.Health) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.health = health
+	p.write(false)
+}
+
+// publishStop records that the virtual machine stopped.
+func (p *statePublisher) publishStop(state string, reason vz.ExitReason, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.state = state
+	p.metrics.recordState(state)
+	p.health = ""
+	p.recordExit(reason, err)
+	p.write(false)
+}
+
+// publishRestart records that the virtual machine stopped because of reason
+// and err, and is restarted for the attempt-th time after backoff.
+func (p *statePublisher) publishRestart(reason vz.ExitReason, err error, attempt uint, backoff time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.state = restartingState
+	p.metrics.recordState(p.state)
+	p.health = ""
+	p.recordExit(reason, err)
+	p.restarts = append(p.restarts, vz.Restart{
+		Exit:    *p.lastExit,
+		Attempt: attempt,
+		Backoff: uint(backoff / time.Second),
+	})
+	if len(p.restarts) > maxRestartHistory {
+		p.restarts = p.restarts[len(p.restarts)-maxRestartHistory:]
+	}
+	p.write(false)
+}
+
+// publishExit records that the launcher is exiting, taking the virtual
+// machine down with it.
+func (p *statePublisher) publishExit(reason vz.ExitReason, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.state = "Stopped"
+	if reason == vz.ExitReasonVMError {
+		p.state = "Error"
+	}
+	p.metrics.recordState(p.state)
+	p.health = ""
+	p.recordExit(reason, err)
+	p.write(true)
+}
+
+func (p *statePublisher) recordExit(reason vz.ExitReason, err error) {
+	p.lastExit = &vz.Exit{
+		Timestamp: time.Now(),
+		Reason:    reason,
+	}
+	if err != nil {
+		p.lastExit.Error = err.Error()
+	}
+}
+
+func (p *statePublisher) write(exited bool) {
+	if p.path == "" {
+		return
+	}
+
+	status := vz.Status{
+		State:     p.state,
+		Timestamp: time.Now(),
+		Pid:       os.Getpid(),
+		Exited:    exited,
+		LastExit:  p.lastExit,
+		Restarts:  p.restarts,
+		Health:    p.health,
+	}
+	if err := vz.WriteStateFile(p.path, &status); err != nil {
+		log.Println("Failed to write state file:", err)
+	}
+}
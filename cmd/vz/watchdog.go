@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/agent"
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+	"github.com/pkg/errors"
+)
+
+// watchdog tracks the health of the guest by the heartbeats vz-agent sends
+// to agent.HeartbeatPort. The guest is healthy from its first heartbeat on,
+// and unhealthy once it sent none for the timeout.
+type watchdog struct {
+	listener  net.Listener
+	timeout   time.Duration
+	publisher *statePublisher
+
+	// unhealthy receives a value whenever the guest becomes unhealthy.
+	unhealthy chan struct{}
+
+	mu            sync.Mutex
+	closed        bool
+	health        vz.Health
+	lastHeartbeat time.Time
+	timer         *time.Timer
+}
+
+// startWatchdog listens for the heartbeats of the guest of vm.
+func startWatchdog(vm vz.Machine, timeout time.Duration, publisher *statePublisher) (*watchdog, error) {
+	listener, err := vm.ListenOnPort(agent.HeartbeatPort)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to listen for heartbeats")
+	}
+
+	w := &watchdog{
+		listener:  listener,
+		timeout:   timeout,
+		publisher: publisher,
+		unhealthy: make(chan struct{}, 1),
+		health:    vz.HealthWaiting,
+	}
+	publisher.publishHealth(w.health)
+	go w.serve()
+
+	return w, nil
+}
+
+func (w *watchdog) serve() {
+	for {
+		conn, err := w.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			if err := agent.ReadHeartbeats(conn, w.beat); err != io.EOF {
+				log.Println("Heartbeat connection failed:", err)
+			}
+		}()
+	}
+}
+
+// beat records a heartbeat of the guest.
+func (w *watchdog) beat() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	w.lastHeartbeat = time.Now()
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.timeout, w.expire)
+	} else {
+		w.timer.Reset(w.timeout)
+	}
+
+	if w.health != vz.HealthHealthy {
+		log.Println("Guest is healthy")
+		w.setHealth(vz.HealthHealthy)
+	}
+}
+
+// expire marks the guest unhealthy, unless a heartbeat came in while the
+// timer fired.
+func (w *watchdog) expire() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed || time.Since(w.lastHeartbeat) < w.timeout {
+		return
+	}
+
+	log.Printf("No heartbeat from the guest for %s, marking it unhealthy", w.timeout)
+	w.setHealth(vz.HealthUnhealthy)
+	select {
+	case w.unhealthy <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watchdog) setHealth(health vz.Health) {
+	w.health = health
+	w.publisher.publishHealth(health)
+}
+
+// status returns the health of the guest and when it last sent a
+// heartbeat, which is zero if it never did.
+func (w *watchdog) status() (vz.Health, time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.health, w.lastHeartbeat
+}
+
+// close stops listening for heartbeats.
+func (w *watchdog) close() {
+	w.mu.Lock()
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+
+	w.listener.Close()
+}
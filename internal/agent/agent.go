@@ -0,0 +1,151 @@
+// Package agent implements the protocol between the vz launcher and
+// vz-agent, which runs in the guest and relays connections made by the
+// launcher over virtio-vsock to guest TCP ports.
+//
+// A connection starts with a request line from the launcher, "CONNECT
+// <port>", answered by "OK" once the agent connected to the guest port, or
+// by "ERROR <message>". Afterwards the connection carries the relayed data.
+//
+// The agent also connects to HeartbeatPort of the host and sends a line,
+// "HEARTBEAT", at a fixed interval, for the launcher to tell whether the guest
+// is alive.
+package agent
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Port is the vsock port the agent listens on.
+const Port uint32 = 1024
+
+// maxLineLength bounds request and response lines.
+const maxLineLength = 256
+
+// Connect asks the agent at the other end of conn to connect to the guest
+// TCP port. Once it returns successfully, conn is relayed to that port.
+func Connect(conn io.ReadWriter, port uint16) error {
+	if _, err := fmt.Fprintf(conn, "CONNECT %d\n", port); err != nil {
+		return errors.Wrap(err, "Failed to send request to agent")
+	}
+
+	response, err := readLine(conn)
+	if err != nil {
+		return errors.Wrap(err, "Failed to read response from agent")
+	}
+	if response == "OK" {
+		return nil
+	}
+	if message := strings.TrimPrefix(response, "ERROR "); message != response {
+		return errors.Errorf("Agent failed to connect to guest port %d: %s", port, message)
+	}
+	return errors.Errorf("Unexpected response from agent: %q", response)
+}
+
+// Serve handles the connections accepted by listener, connecting them to
+// guest ports with dial, until listener is closed.
+func Serve(listener net.Listener, dial func(port uint16) (net.Conn, error)) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go ServeConn(conn, dial)
+	}
+}
+
+// ServeConn handles a single connection from the launcher.
+func ServeConn(conn net.Conn, dial func(port uint16) (net.Conn, error)) {
+	request, err := readLine(conn)
+	if err != nil {
+		log.Println("Failed to read request:", err)
+		conn.Close()
+		return
+	}
+
+	port, err := parseConnect(request)
+	if err == nil {
+		var target net.Conn
+		if target, err = dial(port); err == nil {
+			if _, err := io.WriteString(conn, "OK\n"); err != nil {
+				target.Close()
+				conn.Close()
+				return
+			}
+			Relay(conn, target)
+			return
+		}
+	}
+
+	fmt.Fprintf(conn, "ERROR %s\n", err)
+	conn.Close()
+}
+
+func parseConnect(request string) (uint16, error) {
+	fields := strings.Fields(request)
+	if len(fields) != 2 || fields[0] != "CONNECT" {
+		return 0, errors.Errorf("unknown request %q", request)
+	}
+
+	port, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil || port == 0 {
+		return 0, errors.Errorf("invalid port %q", fields[1])
+	}
+	return uint16(port), nil
+}
+
+// readLine reads a single line from r without reading past it, as the
+// remainder of the connection is relayed.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < maxLineLength {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("line too long")
+}
+
+// closeWriter is implemented by connections that can be half-closed, such
+// as *net.TCPConn.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Relay copies data between a and b in both directions until both are
+// done, then closes them. When one side stops sending, the other is
+// half-closed if it supports it, and closed otherwise.
+func Relay(a, b io.ReadWriteCloser) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	copyHalf := func(dst, src io.ReadWriteCloser) {
+		defer wg.Done()
+
+		io.Copy(dst, src)
+		if cw, ok := dst.(closeWriter); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+
+	wg.Wait()
+	a.Close()
+	b.Close()
+}
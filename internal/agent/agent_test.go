@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestConnect(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	targetPort := uint16(target.Addr().(*net.TCPAddr).Port)
+
+	dial := func(port uint16) (net.Conn, error) {
+		if port != targetPort {
+			return nil, errors.New("connection refused")
+		}
+		return net.Dial("tcp", target.Addr().String())
+	}
+
+	tests := []struct {
+		msg     string
+		port    uint16
+		wantErr string
+	}{
+		{msg: "relayed", port: targetPort},
+		{msg: "refused", port: targetPort + 1, wantErr: "connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			host, guest := net.Pipe()
+			defer host.Close()
+			go ServeConn(guest, dial)
+
+			err := Connect(host, tt.port)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Wanted error containing %q but got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if _, err := io.WriteString(host, "ping"); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, 4)
+			if _, err := io.ReadFull(host, got); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "ping" {
+				t.Errorf("Wanted echo %q but got %q", "ping", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"bufio"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HeartbeatPort is the vsock port of the host the agent sends heartbeats
+// to.
+const HeartbeatPort uint32 = 1025
+
+// heartbeat is the line the agent sends to tell the launcher the guest is
+// alive.
+const heartbeat = "HEARTBEAT"
+
+// SendHeartbeats writes a heartbeat to w right away and then every
+// interval, until writing fails.
+func SendHeartbeats(w io.Writer, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := io.WriteString(w, heartbeat+"\n"); err != nil {
+			return errors.Wrap(err, "Failed to send heartbeat")
+		}
+		<-ticker.C
+	}
+}
+
+// ReadHeartbeats calls beat for every heartbeat read from r, until reading
+// fails. It returns io.EOF once the agent closed the connection.
+func ReadHeartbeats(r io.Reader, beat func()) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, maxLineLength), maxLineLength)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != heartbeat {
+			return errors.Errorf("Unexpected heartbeat %q", line)
+		}
+		beat()
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "Failed to read heartbeat")
+	}
+	return io.EOF
+}
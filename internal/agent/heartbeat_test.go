@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeats(t *testing.T) {
+	host, guest := net.Pipe()
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- SendHeartbeats(guest, time.Millisecond)
+	}()
+
+	beats := 0
+	err := ReadHeartbeats(host, func() {
+		if beats++; beats == 3 {
+			guest.Close()
+		}
+	})
+	if err != io.EOF {
+		t.Errorf("Wanted io.EOF but got %v", err)
+	}
+	if beats != 3 {
+		t.Errorf("Wanted 3 heartbeats but got %d", beats)
+	}
+	if err := <-sent; err == nil {
+		t.Error("Wanted SendHeartbeats to fail once the connection is closed")
+	}
+
+	err = ReadHeartbeats(strings.NewReader("HEARTBEAT\nCONNECT 80\n"), func() {})
+	if err == nil || !strings.Contains(err.Error(), "Unexpected heartbeat") {
+		t.Errorf("Wanted an unexpected heartbeat error but got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+//go:build linux
+// +build linux
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// VsockAddr is the address of a vsock endpoint.
+type VsockAddr struct {
+	CID  uint32
+	Port uint32
+}
+
+func (a *VsockAddr) Network() string { return "vsock" }
+func (a *VsockAddr) String() string  { return fmt.Sprintf("%d:%d", a.CID, a.Port) }
+
+// ListenVsock listens for connections from the host on the vsock port.
+func ListenVsock(port uint32) (net.Listener, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create vsock socket")
+	}
+	if err := unix.Bind(fd, &unix.SockaddrVM{CID: unix.VMADDR_CID_ANY, Port: port}); err != nil {
+		unix.Close(fd)
+		return nil, errors.Wrapf(err, "Failed to bind vsock port %d", port)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		unix.Close(fd)
+		return nil, errors.Wrapf(err, "Failed to listen on vsock port %d", port)
+	}
+
+	return &vsockListener{
+		file: os.NewFile(uintptr(fd), fmt.Sprintf("vsock:%d", port)),
+		addr: &VsockAddr{CID: unix.VMADDR_CID_ANY, Port: port},
+	}, nil
+}
+
+// DialVsock connects to the vsock port of the host.
+func DialVsock(port uint32) (net.Conn, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create vsock socket")
+	}
+	remote := &VsockAddr{CID: unix.VMADDR_CID_HOST, Port: port}
+	if err := unix.Connect(fd, &unix.SockaddrVM{CID: remote.CID, Port: remote.Port}); err != nil {
+		unix.Close(fd)
+		return nil, errors.Wrapf(err, "Failed to connect to vsock port %d", port)
+	}
+	// Connect without the runtime poller, then hand the socket over to it.
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, errors.Wrapf(err, "Failed to connect to vsock port %d", port)
+	}
+
+	local := &VsockAddr{}
+	if sa, err := unix.Getsockname(fd); err == nil {
+		if vm, ok := sa.(*unix.SockaddrVM); ok {
+			local = &VsockAddr{CID: vm.CID, Port: vm.Port}
+		}
+	}
+	return &vsockConn{
+		File:   os.NewFile(uintptr(fd), "vsock:"+remote.String()),
+		local:  local,
+		remote: remote,
+	}, nil
+}
+
+// vsockListener accepts vsock connections through the runtime poller, so
+// that Close interrupts Accept.
+type vsockListener struct {
+	file *os.File
+	addr *VsockAddr
+}
+
+func (l *vsockListener) Accept() (net.Conn, error) {
+	rawConn, err := l.file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var nfd int
+	var sa unix.Sockaddr
+	var acceptErr error
+	err = rawConn.Read(func(fd uintptr) bool {
+		nfd, sa, acceptErr = unix.Accept4(int(fd), unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK)
+		return acceptErr != unix.EAGAIN
+	})
+	if err != nil {
+		return nil, err
+	}
+	if acceptErr != nil {
+		return nil, acceptErr
+	}
+
+	remote := &VsockAddr{}
+	if vm, ok := sa.(*unix.SockaddrVM); ok {
+		remote = &VsockAddr{CID: vm.CID, Port: vm.Port}
+	}
+	return &vsockConn{
+		File:   os.NewFile(uintptr(nfd), "vsock:"+remote.String()),
+		local:  l.addr,
+		remote: remote,
+	}, nil
+}
+
+func (l *vsockListener) Close() error   { return l.file.Close() }
+func (l *vsockListener) Addr() net.Addr { return l.addr }
+
+// vsockConn is a vsock connection.
+type vsockConn struct {
+	*os.File
+	local  *VsockAddr
+	remote *VsockAddr
+}
+
+func (c *vsockConn) LocalAddr() net.Addr  { return c.local }
+func (c *vsockConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *vsockConn) CloseWrite() error {
+	rawConn, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var shutdownErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		shutdownErr = unix.Shutdown(int(fd), unix.SHUT_WR)
+	}); err != nil {
+		return err
+	}
+	return shutdownErr
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// ListenVsock listens for connections from the host on the vsock port.
+// vsock is only supported in Linux guests.
+func ListenVsock(port uint32) (net.Listener, error) {
+	return nil, errors.New("vsock is not supported on this system")
+}
+
+// DialVsock connects to the vsock port of the host. vsock is only supported
+// in Linux guests.
+func DialVsock(port uint32) (net.Conn, error) {
+	return nil, errors.New("vsock is not supported on this system")
+}
@@ -0,0 +1,75 @@
+package console
+
+import (
+	"io"
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+)
+
+// EscapeChar detaches from a console when typed, as in telnet (Ctrl-]).
+const EscapeChar = 0x1d
+
+// Attach connects in and out to the console served at socketPath until the
+// server closes the connection or EscapeChar is read from in. If in is a
+// terminal, it is put in raw mode for the duration.
+func Attach(socketPath string, in *os.File, out io.Writer) error {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return errors.Wrap(err, "Failed to connect to console")
+	}
+	defer conn.Close()
+
+	if fd := int(in.Fd()); term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return errors.Wrap(err, "Failed to set up terminal")
+		}
+		defer term.Restore(fd, state)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(out, conn)
+		close(closed)
+	}()
+
+	typed := make(chan error, 1)
+	go func() {
+		typed <- copyUntilEscape(conn, in)
+	}()
+
+	select {
+	case <-closed:
+		return nil
+	case err := <-typed:
+		return err
+	}
+}
+
+// copyUntilEscape copies src to dst until EscapeChar is read.
+func copyUntilEscape(dst io.Writer, src io.Reader) error {
+	buf := make([]byte, 1024)
+	for {
+		n, err := src.Read(buf)
+		for i := 0; i < n; i++ {
+			if buf[i] == EscapeChar {
+				_, werr := dst.Write(buf[:i])
+				return werr
+			}
+		}
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package console
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// PTY is a pseudo-terminal whose master side is attached to a virtual
+// machine serial port, and whose slave side is opened by terminal programs
+// such as screen.
+type PTY struct {
+	// Master is attached to the virtual machine.
+	Master *os.File
+	// Name is the path of the slave device, e.g. /dev/ttys004.
+	Name string
+	// link is the symlink to the slave device created by OpenPTY, if any.
+	link string
+}
+
+// OpenPTY allocates a pseudo-terminal. If link is set, a symlink to the
+// slave device is created there, replacing any previous one.
+func OpenPTY(link string) (*PTY, error) {
+	master, name, err := openPTY()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to allocate pseudo-terminal")
+	}
+
+	p := &PTY{Master: master, Name: name}
+	if link != "" {
+		if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+			master.Close()
+			return nil, errors.Wrap(err, "Failed to remove stale pseudo-terminal link")
+		}
+		if err := os.Symlink(name, link); err != nil {
+			master.Close()
+			return nil, errors.Wrap(err, "Failed to link pseudo-terminal")
+		}
+		p.link = link
+	}
+
+	return p, nil
+}
+
+// Close releases the pseudo-terminal and removes its symlink.
+func (p *PTY) Close() error {
+	if p.link != "" {
+		os.Remove(p.link)
+	}
+	return p.Master.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package console
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+func openPTY() (*os.File, string, error) {
+	return nil, "", errors.New("pseudo-terminals are not supported on this host")
+}
@@ -0,0 +1,116 @@
+package console
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RotatingFile is a log file that is rotated once it would grow beyond a
+// maximum size. The previous generations are kept as path.1 (the newest) to
+// path.N. An existing log is appended to, so it survives restarts.
+type RotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the log file at path, keeping at most maxFiles
+// previous generations of at most maxSize bytes each.
+func OpenRotatingFile(path string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "Failed to open log file")
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "Failed to open log file")
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write appends p to the log, rotating it first if p doesn't fit. Writes
+// larger than the maximum size are not split.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts every generation up by one, dropping the oldest, and
+// starts a new log.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return errors.Wrap(err, "Failed to close log file")
+	}
+	f.file = nil
+
+	if f.maxFiles == 0 {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "Failed to rotate log file")
+		}
+		return f.open()
+	}
+
+	if err := os.Remove(f.generation(f.maxFiles)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "Failed to rotate log file")
+	}
+	for i := f.maxFiles - 1; i > 0; i-- {
+		if err := os.Rename(f.generation(i), f.generation(i+1)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "Failed to rotate log file")
+		}
+	}
+	if err := os.Rename(f.path, f.generation(1)); err != nil {
+		return errors.Wrap(err, "Failed to rotate log file")
+	}
+
+	return f.open()
+}
+
+func (f *RotatingFile) generation(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
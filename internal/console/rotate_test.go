@@ -0,0 +1,52 @@
+package console
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "console.log")
+	if err := os.WriteFile(path, []byte("boot 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenRotatingFile(path, 14, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"boot 2\n", "boot 3\n", "boot 4\n", "boot 5\n", "boot 6\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		msg  string
+		path string
+		want string
+	}{
+		{msg: "current", path: path, want: "boot 5\nboot 6\n"},
+		{msg: "newest generation", path: path + ".1", want: "boot 3\nboot 4\n"},
+		{msg: "oldest generation", path: path + ".2", want: "boot 1\nboot 2\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			got, err := os.ReadFile(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Wanted %q but got %q", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Wanted at most 2 generations but got %s.3", path)
+	}
+}
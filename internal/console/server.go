@@ -0,0 +1,181 @@
+// Package console connects virtual machine serial ports to host terminals.
+package console
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// clientWriteTimeout bounds how long a stalled client may hold up console
+// output before it is disconnected.
+const clientWriteTimeout = 5 * time.Second
+
+// Server relays a virtual machine serial port to the clients connected to
+// a Unix socket. Guest output is sent to every client and to the log, if
+// any, and input from any client is sent to the guest.
+type Server struct {
+	listener net.Listener
+	log      io.Writer
+
+	// vmRead and vmWrite are the ends of the pipes attached to the
+	// virtual machine, input and output the ends used by the server.
+	vmRead  *os.File
+	vmWrite *os.File
+	input   *os.File
+	output  *os.File
+
+	mu      sync.Mutex
+	clients map[net.Conn]struct{}
+}
+
+// Listen creates a Unix socket at path serving a new serial port. If log is
+// not nil, guest output is also written to it, whether or not clients are
+// connected.
+func Listen(path string, log io.Writer) (*Server, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "Failed to remove stale console socket")
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to listen on console socket")
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, errors.Wrap(err, "Failed to set console socket permissions")
+	}
+
+	vmRead, input, err := os.Pipe()
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	output, vmWrite, err := os.Pipe()
+	if err != nil {
+		listener.Close()
+		vmRead.Close()
+		input.Close()
+		return nil, err
+	}
+
+	s := &Server{
+		listener: listener,
+		log:      log,
+		vmRead:   vmRead,
+		vmWrite:  vmWrite,
+		input:    input,
+		output:   output,
+		clients:  map[net.Conn]struct{}{},
+	}
+
+	go s.accept()
+	go s.relayOutput()
+
+	return s, nil
+}
+
+// Files returns the files to attach to the virtual machine serial port: the
+// guest reads its input from read and writes its output to write.
+func (s *Server) Files() (read, write *os.File) {
+	return s.vmRead, s.vmWrite
+}
+
+// Close disconnects all clients and stops serving the serial port.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for client := range s.clients {
+		client.Close()
+	}
+	s.mu.Unlock()
+
+	for _, f := range []*os.File{s.vmRead, s.vmWrite, s.input, s.output} {
+		f.Close()
+	}
+
+	return err
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.clients[conn] = struct{}{}
+		s.mu.Unlock()
+
+		go s.relayInput(conn)
+	}
+}
+
+// relayInput forwards what the client types to the guest.
+func (s *Server) relayInput(conn net.Conn) {
+	defer s.disconnect(conn)
+
+	if _, err := io.Copy(s.input, conn); err != nil {
+		log.Println("Console input failed:", err)
+	}
+}
+
+// relayOutput forwards guest output to every client. Output is consumed
+// even without clients so the guest never blocks on its console.
+func (s *Server) relayOutput() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := s.output.Read(buf)
+		if n > 0 {
+			s.writeLog(buf[:n])
+			s.broadcast(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeLog copies output to the log, giving up on the log after the first
+// failure.
+func (s *Server) writeLog(data []byte) {
+	if s.log == nil {
+		return
+	}
+
+	if _, err := s.log.Write(data); err != nil {
+		log.Println("Failed to write console log, disabling it:", err)
+		s.log = nil
+	}
+}
+
+func (s *Server) broadcast(data []byte) {
+	s.mu.Lock()
+	clients := make([]net.Conn, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mu.Unlock()
+
+	for _, client := range clients {
+		client.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		if _, err := client.Write(data); err != nil {
+			s.disconnect(client)
+		}
+	}
+}
+
+func (s *Server) disconnect(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.clients, conn)
+	s.mu.Unlock()
+
+	conn.Close()
+}
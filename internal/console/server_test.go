@@ -0,0 +1,59 @@
+package console
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "console.sock")
+	server, err := Listen(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	vmRead, vmWrite := server.Files()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The client is registered asynchronously, so keep writing until
+	// output arrives.
+	received := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+	var got string
+	for got == "" {
+		if _, err := vmWrite.Write([]byte("login:\n")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got = <-received:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if got != "login:\n" {
+		t.Errorf("Wanted guest output %q but got %q", "login:\n", got)
+	}
+
+	if _, err := conn.Write([]byte("root\n")); err != nil {
+		t.Fatal(err)
+	}
+	vmRead.SetDeadline(time.Now().Add(5 * time.Second))
+	input, err := bufio.NewReader(vmRead).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input != "root\n" {
+		t.Errorf("Wanted guest input %q but got %q", "root\n", input)
+	}
+}
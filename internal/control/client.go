@@ -0,0 +1,147 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+	"github.com/pkg/errors"
+)
+
+// ErrUnavailable is returned by the Client when the launcher can't be
+// reached, e.g. because it is not running.
+var ErrUnavailable = errors.New("vz control socket unavailable")
+
+const clientTimeout = 30 * time.Second
+
+// SocketFileName is the name of the control socket in a machine directory.
+const SocketFileName = "vz.sock"
+
+// Client talks to the control API of a running launcher.
+type Client struct {
+	httpClient *http.Client
+}
+
+// NewClient returns a Client for the launcher listening on socketPath.
+func NewClient(socketPath string) *Client {
+	dialer := net.Dialer{}
+	return &Client{
+		httpClient: &http.Client{
+			Timeout: clientTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// State returns the name of the current state of the virtual machine.
+func (c *Client) State() (string, error) {
+	var response StateResponse
+	if err := c.do(http.MethodGet, pathState, nil, &response); err != nil {
+		return "", err
+	}
+	return response.State, nil
+}
+
+// Config returns the configuration the virtual machine was started with.
+func (c *Client) Config() (*vz.VirtualMachineConfig, error) {
+	var document vz.ConfigDocument
+	if err := c.do(http.MethodGet, pathConfig, nil, &document); err != nil {
+		return nil, err
+	}
+	return &document.Machine, nil
+}
+
+// RequestStop asks the guest to shut down.
+func (c *Client) RequestStop() error {
+	return c.do(http.MethodPost, pathRequestStop, nil, nil)
+}
+
+// ForceStop stops the virtual machine immediately.
+func (c *Client) ForceStop() error {
+	return c.do(http.MethodPost, pathForceStop, nil, nil)
+}
+
+func (c *Client) Pause() error {
+	return c.do(http.MethodPost, pathPause, nil, nil)
+}
+
+func (c *Client) Resume() error {
+	return c.do(http.MethodPost, pathResume, nil, nil)
+}
+
+// Memory returns the memory target of the guest.
+func (c *Client) Memory() (*MemoryResponse, error) {
+	var response MemoryResponse
+	if err := c.do(http.MethodGet, pathMemory, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// SetMemoryTarget asks the guest to use size MiB of memory.
+func (c *Client) SetMemoryTarget(size uint) (*MemoryResponse, error) {
+	var response MemoryResponse
+	if err := c.do(http.MethodPost, pathMemory, MemoryRequest{Target: size}, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Health returns the health of the guest tracked by the watchdog.
+func (c *Client) Health() (*HealthResponse, error) {
+	var response HealthResponse
+	if err := c.do(http.MethodGet, pathHealth, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// do sends body, if not nil, and decodes the response into v, if not nil.
+func (c *Client) do(method, path string, body, v interface{}) error {
+	var requestBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&requestBody).Encode(body); err != nil {
+			return errors.Wrapf(err, "%s %s: encoding request", method, path)
+		}
+	}
+
+	request, err := http.NewRequest(method, "http://vz"+path, &requestBody)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return errors.Wrap(ErrUnavailable, opErr.Error())
+		}
+		return errors.Wrapf(err, "%s %s", method, path)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var errorResponse ErrorResponse
+		if err := json.NewDecoder(response.Body).Decode(&errorResponse); err != nil {
+			return errors.Errorf("%s %s: %s", method, path, response.Status)
+		}
+		return errors.New(errorResponse.Error)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "%s %s: decoding response", method, path)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package control
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestClient_Unavailable(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), SocketFileName)
+
+	// A socket left behind by a launcher that exited refuses connections.
+	stale := filepath.Join(t.TempDir(), SocketFileName)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: stale, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.SetUnlinkOnClose(false)
+	listener.Close()
+
+	tests := []struct {
+		msg  string
+		path string
+	}{
+		{
+			msg:  "missing socket",
+			path: missing,
+		}, {
+			msg:  "stale socket",
+			path: stale,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			_, err := NewClient(tt.path).State()
+			if errors.Cause(err) != ErrUnavailable {
+				t.Errorf("Wanted ErrUnavailable but got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+// Package control implements the API the vz launcher serves on a Unix
+// socket in the machine directory, and a client for it.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+	"github.com/pkg/errors"
+)
+
+// Machine is implemented by the launcher to expose a virtual machine over
+// the control API.
+type Machine interface {
+	// State returns the name of the current state of the virtual machine.
+	State() string
+	// RequestStop asks the guest to shut down.
+	RequestStop() error
+	// ForceStop stops the virtual machine immediately.
+	ForceStop() error
+	Pause() error
+	Resume() error
+	// Config returns the configuration the virtual machine was started with.
+	Config() *vz.VirtualMachineConfig
+	// MemoryTarget returns the memory the guest is asked to use, in MiB.
+	MemoryTarget() (uint, error)
+	// SetMemoryTarget asks the guest to use size MiB of memory, at most the
+	// memory it was started with.
+	SetMemoryTarget(size uint) error
+	// Health returns the health of the guest tracked by the watchdog, and
+	// when it last sent a heartbeat.
+	Health() (vz.Health, time.Time, error)
+}
+
+// StateResponse is returned by the state operation.
+type StateResponse struct {
+	State string `json:"state"`
+}
+
+// MemoryRequest sets the memory target of the guest, in MiB.
+type MemoryRequest struct {
+	Target uint `json:"target"`
+}
+
+// MemoryResponse is returned by the memory operations. Sizes are in MiB.
+type MemoryResponse struct {
+	Target  uint `json:"target"`
+	Maximum uint `json:"maximum"`
+}
+
+// HealthResponse is returned by the health operation. LastHeartbeat is
+// omitted until the guest sent a heartbeat.
+type HealthResponse struct {
+	Health        vz.Health  `json:"health"`
+	LastHeartbeat *time.Time `json:"lastHeartbeat,omitempty"`
+}
+
+// ErrorResponse is returned by any operation that fails.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+const (
+	pathState       = "/v1/state"
+	pathConfig      = "/v1/config"
+	pathRequestStop = "/v1/request-stop"
+	pathForceStop   = "/v1/force-stop"
+	pathPause       = "/v1/pause"
+	pathResume      = "/v1/resume"
+	pathMemory      = "/v1/memory"
+	pathHealth      = "/v1/health"
+)
+
+// Server serves the control API for a Machine.
+type Server struct {
+	server *http.Server
+
+	mu      sync.Mutex
+	machine Machine
+}
+
+func NewServer(machine Machine) *Server {
+	s := &Server{machine: machine}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathState, s.get(func() (interface{}, error) {
+		return StateResponse{State: s.current().State()}, nil
+	}))
+	mux.HandleFunc(pathConfig, s.get(func() (interface{}, error) {
+		return vz.ConfigDocument{
+			Version: vz.ConfigVersion,
+			Machine: *s.current().Config(),
+		}, nil
+	}))
+	mux.HandleFunc(pathRequestStop, s.post(func() error { return s.current().RequestStop() }))
+	mux.HandleFunc(pathForceStop, s.post(func() error { return s.current().ForceStop() }))
+	mux.HandleFunc(pathPause, s.post(func() error { return s.current().Pause() }))
+	mux.HandleFunc(pathResume, s.post(func() error { return s.current().Resume() }))
+	mux.HandleFunc(pathMemory, s.memory)
+	mux.HandleFunc(pathHealth, s.get(func() (interface{}, error) {
+		health, lastHeartbeat, err := s.current().Health()
+		if err != nil {
+			return nil, err
+		}
+		response := HealthResponse{Health: health}
+		if !lastHeartbeat.IsZero() {
+			response.LastHeartbeat = &lastHeartbeat
+		}
+		return response, nil
+	}))
+
+	s.server = &http.Server{Handler: mux}
+
+	return s
+}
+
+// SetMachine makes the server expose machine instead, e.g. after the
+// launcher replaced a rebooted virtual machine.
+func (s *Server) SetMachine(machine Machine) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.machine = machine
+}
+
+func (s *Server) current() Machine {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.machine
+}
+
+// Listen creates a Unix socket at path that is only accessible by its
+// owner, replacing any stale socket left behind by a previous launcher.
+func Listen(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "Failed to remove stale control socket")
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to listen on control socket")
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, errors.Wrap(err, "Failed to set control socket permissions")
+	}
+
+	return listener, nil
+}
+
+// Serve accepts connections on listener until the server is shut down.
+func (s *Server) Serve(listener net.Listener) error {
+	if err := s.server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops the server, waiting for in-flight requests to complete.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) get(handler func() (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.Errorf("Method %s not allowed", r.Method))
+			return
+		}
+
+		response, err := handler()
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+func (s *Server) post(handler func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errors.Errorf("Method %s not allowed", r.Method))
+			return
+		}
+
+		if err := handler(); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, StateResponse{State: s.current().State()})
+	}
+}
+
+// memory reports the memory target on GET and changes it on POST.
+func (s *Server) memory(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var request MemoryRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid memory request"))
+			return
+		}
+		if err := s.current().SetMemoryTarget(request.Target); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.Errorf("Method %s not allowed", r.Method))
+		return
+	}
+
+	machine := s.current()
+	target, err := machine.MemoryTarget()
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, MemoryResponse{Target: target, Maximum: machine.Config().Memory})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
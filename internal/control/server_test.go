@@ -0,0 +1,286 @@
+package control
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+	"github.com/pkg/errors"
+)
+
+// fakeMachine records the operations done on it, which fail with err if
+// it is set.
+type fakeMachine struct {
+	mu        sync.Mutex
+	state     string
+	memory    uint
+	err       error
+	heartbeat time.Time
+	calls     []string
+}
+
+func (m *fakeMachine) call(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, name)
+	return m.err
+}
+
+func (m *fakeMachine) State() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+func (m *fakeMachine) RequestStop() error { return m.call("RequestStop") }
+func (m *fakeMachine) ForceStop() error   { return m.call("ForceStop") }
+func (m *fakeMachine) Pause() error       { return m.call("Pause") }
+func (m *fakeMachine) Resume() error      { return m.call("Resume") }
+
+func (m *fakeMachine) Config() *vz.VirtualMachineConfig {
+	return &vz.VirtualMachineConfig{CPUs: 2, Memory: 2048}
+}
+
+func (m *fakeMachine) MemoryTarget() (uint, error) {
+	if err := m.call("MemoryTarget"); err != nil {
+		return 0, err
+	}
+	return m.memory, nil
+}
+
+func (m *fakeMachine) SetMemoryTarget(size uint) error {
+	if err := m.call("SetMemoryTarget"); err != nil {
+		return err
+	}
+	m.memory = size
+	return nil
+}
+
+func (m *fakeMachine) Health() (vz.Health, time.Time, error) {
+	if err := m.call("Health"); err != nil {
+		return "", time.Time{}, err
+	}
+	return vz.HealthHealthy, m.heartbeat, nil
+}
+
+// startServer serves the control API for machine on a socket, returning a
+// client of it.
+func startServer(t *testing.T, machine Machine) (*Server, *Client) {
+	path := filepath.Join(t.TempDir(), SocketFileName)
+	listener, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(machine)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.server.Close() })
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Wanted a socket only accessible by its owner but got mode %#o", perm)
+	}
+	return server, NewClient(path)
+}
+
+func TestClient(t *testing.T) {
+	heartbeat := time.Date(2022, 6, 5, 12, 0, 0, 0, time.UTC)
+	machine := &fakeMachine{state: "running", memory: 2048, heartbeat: heartbeat}
+	_, client := startServer(t, machine)
+
+	if state, err := client.State(); err != nil || state != "running" {
+		t.Errorf("Wanted state running but got %q (%v)", state, err)
+	}
+	if config, err := client.Config(); err != nil || config.CPUs != 2 || config.Memory != 2048 {
+		t.Errorf("Wanted the machine's config but got %+v (%v)", config, err)
+	}
+	for _, op := range []struct {
+		name string
+		do   func() error
+	}{
+		{"RequestStop", client.RequestStop},
+		{"ForceStop", client.ForceStop},
+		{"Pause", client.Pause},
+		{"Resume", client.Resume},
+	} {
+		if err := op.do(); err != nil {
+			t.Errorf("Unexpected error of %s: %s", op.name, err)
+		}
+	}
+	if memory, err := client.SetMemoryTarget(1024); err != nil || *memory != (MemoryResponse{Target: 1024, Maximum: 2048}) {
+		t.Errorf("Wanted a memory target of 1024 MiB but got %+v (%v)", memory, err)
+	}
+	if memory, err := client.Memory(); err != nil || *memory != (MemoryResponse{Target: 1024, Maximum: 2048}) {
+		t.Errorf("Wanted a memory target of 1024 MiB but got %+v (%v)", memory, err)
+	}
+	if health, err := client.Health(); err != nil || health.Health != vz.HealthHealthy || !health.LastHeartbeat.Equal(heartbeat) {
+		t.Errorf("Wanted a healthy guest but got %+v (%v)", health, err)
+	}
+
+	want := []string{"RequestStop", "ForceStop", "Pause", "Resume", "SetMemoryTarget", "MemoryTarget", "MemoryTarget", "Health"}
+	if strings.Join(machine.calls, " ") != strings.Join(want, " ") {
+		t.Errorf("Wanted calls %q but got %q", want, machine.calls)
+	}
+}
+
+func TestClient_MachineError(t *testing.T) {
+	machine := &fakeMachine{state: "stopped", err: errors.New("Virtual machine can't be paused")}
+	_, client := startServer(t, machine)
+
+	for _, op := range []struct {
+		name string
+		do   func() error
+	}{
+		{"Pause", client.Pause},
+		{"Memory", func() error { _, err := client.Memory(); return err }},
+		{"SetMemoryTarget", func() error { _, err := client.SetMemoryTarget(1024); return err }},
+		{"Health", func() error { _, err := client.Health(); return err }},
+	} {
+		if err := op.do(); err == nil || err.Error() != "Virtual machine can't be paused" {
+			t.Errorf("Wanted the machine's error from %s but got %v", op.name, err)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	tests := []struct {
+		msg        string
+		method     string
+		path       string
+		body       string
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			msg:        "state",
+			method:     http.MethodGet,
+			path:       pathState,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"state":"running"}`,
+		}, {
+			msg:        "config",
+			method:     http.MethodGet,
+			path:       pathConfig,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"version":1,"machine":{"kernel":"","initrd":"","cmdLine":"","cpus":2,"memory":2048}}`,
+		}, {
+			msg:        "request stop",
+			method:     http.MethodPost,
+			path:       pathRequestStop,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"state":"running"}`,
+		}, {
+			msg:        "force stop",
+			method:     http.MethodPost,
+			path:       pathForceStop,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"state":"running"}`,
+		}, {
+			msg:        "pause",
+			method:     http.MethodPost,
+			path:       pathPause,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"state":"running"}`,
+		}, {
+			msg:        "resume",
+			method:     http.MethodPost,
+			path:       pathResume,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"state":"running"}`,
+		}, {
+			msg:        "memory",
+			method:     http.MethodGet,
+			path:       pathMemory,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"target":2048,"maximum":2048}`,
+		}, {
+			msg:        "set memory",
+			method:     http.MethodPost,
+			path:       pathMemory,
+			body:       `{"target":1024}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"target":1024,"maximum":2048}`,
+		}, {
+			msg:        "health",
+			method:     http.MethodGet,
+			path:       pathHealth,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"health":"healthy"}`,
+		}, {
+			msg:        "get of an operation",
+			method:     http.MethodGet,
+			path:       pathPause,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   `{"error":"Method GET not allowed"}`,
+		}, {
+			msg:        "post of a query",
+			method:     http.MethodPost,
+			path:       pathState,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   `{"error":"Method POST not allowed"}`,
+		}, {
+			msg:        "delete of memory",
+			method:     http.MethodDelete,
+			path:       pathMemory,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   `{"error":"Method DELETE not allowed"}`,
+		}, {
+			msg:        "invalid memory request",
+			method:     http.MethodPost,
+			path:       pathMemory,
+			body:       `{"target":"1G"}`,
+			wantStatus: http.StatusBadRequest,
+		}, {
+			msg:        "machine error",
+			method:     http.MethodPost,
+			path:       pathPause,
+			err:        errors.New("Virtual machine can't be paused"),
+			wantStatus: http.StatusConflict,
+			wantBody:   `{"error":"Virtual machine can't be paused"}`,
+		}, {
+			msg:        "unknown path",
+			method:     http.MethodGet,
+			path:       "/v1/unknown",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			server := NewServer(&fakeMachine{state: "running", memory: 2048, err: tt.err})
+			recorder := httptest.NewRecorder()
+			server.server.Handler.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("Wanted status %d but got %d", tt.wantStatus, recorder.Code)
+			}
+			if tt.wantBody != "" && strings.TrimSpace(recorder.Body.String()) != tt.wantBody {
+				t.Errorf("Wanted body %s but got %s", tt.wantBody, recorder.Body)
+			}
+			if tt.wantStatus != http.StatusOK && tt.wantStatus != http.StatusNotFound {
+				var response ErrorResponse
+				if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response.Error == "" {
+					t.Errorf("Wanted an error response but got %s (%v)", recorder.Body, err)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_SetMachine(t *testing.T) {
+	server, client := startServer(t, &fakeMachine{state: "stopped"})
+	server.SetMachine(&fakeMachine{state: "running"})
+
+	if state, err := client.State(); err != nil || state != "running" {
+		t.Errorf("Wanted the state of the new machine but got %q (%v)", state, err)
+	}
+}
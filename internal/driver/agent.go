@@ -0,0 +1,82 @@
+package driver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/ssh"
+	"github.com/pkg/errors"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// agentFileName is the name of the Linux vz-agent binary on the host,
+	// which is installed next to vz.
+	agentFileName = "vz-agent"
+
+	guestAgentPath = "/usr/local/bin/vz-agent"
+)
+
+// startAgentCommand replaces vz-agent in the guest with the binary read
+// from stdin and starts it in the background.
+var startAgentCommand = fmt.Sprintf(
+	"sudo sh -c 'cat > %[1]s.new && chmod 755 %[1]s.new && mv %[1]s.new %[1]s && "+
+		"(pkill -x vz-agent; true) && setsid %[1]s > /var/log/vz-agent.log 2>&1 < /dev/null &'",
+	guestAgentPath,
+)
+
+// startAgent copies vz-agent into the guest and starts it, as the boot2docker
+// root file system doesn't survive restarts.
+func (d *Driver) startAgent() error {
+	agentPath, err := exec.LookPath(agentFileName)
+	if err != nil {
+		return errors.Wrap(err, "Unable to find vz-agent, which port forwarding and the watchdog require")
+	}
+	agent, err := os.Open(agentPath)
+	if err != nil {
+		return errors.Wrap(err, "Unable to read vz-agent")
+	}
+	defer agent.Close()
+
+	if err := drivers.WaitForSSH(d); err != nil {
+		return err
+	}
+
+	host, err := d.GetSSHHostname()
+	if err != nil {
+		return err
+	}
+	port, err := d.GetSSHPort()
+	if err != nil {
+		return err
+	}
+	config, err := ssh.NewNativeConfig(d.GetSSHUsername(), &ssh.Auth{Keys: []string{d.GetSSHKeyPath()}})
+	if err != nil {
+		return errors.Wrap(err, "Failed to set up SSH")
+	}
+
+	client, err := gossh.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)), &config)
+	if err != nil {
+		return errors.Wrap(err, "Failed to connect to guest over SSH")
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return errors.Wrap(err, "Failed to connect to guest over SSH")
+	}
+	defer session.Close()
+
+	log.Debugf("Installing %s in the guest", agentPath)
+	session.Stdin = agent
+	if output, err := session.CombinedOutput(startAgentCommand); err != nil {
+		return errors.Wrapf(err, "Failed to start vz-agent: %s", output)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// kernelFileName and initrdFileName are the names in the machine
+	// directory of the files given with --vz-kernel and --vz-initrd.
+	kernelFileName = "kernel"
+	initrdFileName = "initrd"
+
+	checksumPrefix = "sha256:"
+
+	// downloadTimeout bounds how long downloading a kernel or initrd may
+	// take, including reading the response.
+	downloadTimeout = 10 * time.Minute
+)
+
+// downloadClient downloads the kernel and initrd given as URLs.
+var downloadClient = &http.Client{Timeout: downloadTimeout}
+
+// usesISO reports whether the machine boots from or attaches the
+// boot2docker ISO. Machines booting a kernel and initrd given by the user,
+// or not provisioned by boot2docker, only do if an ISO was asked for as
+// well.
+func (d *Driver) usesISO() bool {
+	if d.Boot2DockerURL != "" {
+		return true
+	}
+	return d.provisioning() == ProvisionBoot2Docker && (d.KernelSource == "" || d.InitrdSource == "")
+}
+
+// isURL reports whether source is a URL to download rather than a path.
+func isURL(source string) bool {
+	u, err := url.Parse(source)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// checkBootSource checks that source, a path or URL given for flag, can be
+// fetched later on, as far as that is known before downloading it.
+func checkBootSource(flag, source string) error {
+	if source == "" || isURL(source) {
+		return nil
+	}
+	if _, err := os.Stat(source); err != nil {
+		return errors.Wrapf(err, "Invalid %s", flag)
+	}
+	return nil
+}
+
+// fetchBootFiles copies the kernel and initrd given by the user into the
+// machine directory, recording their checksums.
+func (d *Driver) fetchBootFiles() error {
+	if d.KernelSource != "" {
+		checksum, err := fetchFile(d.KernelSource, d.ResolveStorePath(kernelFileName))
+		if err != nil {
+			return errors.Wrap(err, "Failed to copy kernel")
+		}
+		d.Kernel = kernelFileName
+		d.KernelChecksum = checksum
+	}
+
+	if d.InitrdSource != "" {
+		checksum, err := fetchFile(d.InitrdSource, d.ResolveStorePath(initrdFileName))
+		if err != nil {
+			return errors.Wrap(err, "Failed to copy initrd")
+		}
+		d.Initrd = initrdFileName
+		d.InitrdChecksum = checksum
+	}
+
+	return nil
+}
+
+// verifyBootFiles checks that the kernel and initrd given by the user
+// haven't changed since they were copied into the machine directory.
+func (d *Driver) verifyBootFiles() error {
+	files := []struct {
+		name     string
+		checksum string
+	}{
+		{d.Kernel, d.KernelChecksum},
+		{d.Initrd, d.InitrdChecksum},
+	}
+	for _, file := range files {
+		if file.checksum == "" {
+			continue
+		}
+
+		f, err := os.Open(d.ResolveStorePath(file.name))
+		if err != nil {
+			return err
+		}
+		checksum, err := checksumOf(f)
+		f.Close()
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read %s", file.name)
+		}
+		if checksum != file.checksum {
+			return errors.Errorf("%s has checksum %s, but %s was recorded when it was copied", file.name, checksum, file.checksum)
+		}
+	}
+	return nil
+}
+
+// fetchFile downloads or copies source to dest, returning its checksum.
+func fetchFile(source, dest string) (string, error) {
+	var r io.ReadCloser
+	if isURL(source) {
+		log.Infof("Downloading %s...", source)
+		response, err := downloadClient.Get(source)
+		if err != nil {
+			return "", err
+		}
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			response.Body.Close()
+			return "", errors.Errorf("Failed to download %s: %s", source, response.Status)
+		}
+		r = response.Body
+	} else {
+		log.Debugf("Copying %s into %s", source, dest)
+		f, err := os.Open(source)
+		if err != nil {
+			return "", err
+		}
+		r = f
+	}
+	defer r.Close()
+
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return "", err
+	}
+	checksum, err := checksumOf(io.TeeReader(r, f))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return "", errors.Wrapf(err, "Failed to copy %s", source)
+	}
+	return checksum, nil
+}
+
+// checksumOf returns the checksum of everything read from r.
+func checksumOf(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return checksumPrefix + hex.EncodeToString(hash.Sum(nil)), nil
+}
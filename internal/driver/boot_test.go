@@ -0,0 +1,98 @@
+package driver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDriver_fetchBootFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("initrd"))
+	}))
+	defer server.Close()
+
+	d := newTestDriver(t)
+	d.Kernel, d.Initrd = "", ""
+	d.KernelSource = filepath.Join(t.TempDir(), "bzImage")
+	if err := os.WriteFile(d.KernelSource, []byte("kernel"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d.InitrdSource = server.URL + "/initrd.img"
+
+	if err := d.fetchBootFiles(); err != nil {
+		t.Fatalf("Unexpected error fetching boot files: %s", err)
+	}
+	if d.Kernel != kernelFileName || d.Initrd != initrdFileName {
+		t.Errorf("Unexpected kernel %q and initrd %q", d.Kernel, d.Initrd)
+	}
+	// sha256 of "kernel".
+	if want := "sha256:6923dd1bc0460082c5d55a831908c24a282860b7f1cd6c2b79cf1bc8857c639c"; d.KernelChecksum != want {
+		t.Errorf("Wanted kernel checksum %q but got %q", want, d.KernelChecksum)
+	}
+	if got, err := os.ReadFile(d.ResolveStorePath(initrdFileName)); err != nil || string(got) != "initrd" {
+		t.Errorf("Wanted the initrd to be downloaded but got %q (%v)", got, err)
+	}
+
+	config, err := d.generateVmConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error generating config: %s", err)
+	}
+	if len(config.Disks) != 1 || config.Disks[0].Path != GetDiskPath(d.BaseDriver) {
+		t.Errorf("Wanted only the machine's disk without the ISO but got %+v", config.Disks)
+	}
+
+	if err := d.verifyBootFiles(); err != nil {
+		t.Errorf("Unexpected error verifying boot files: %s", err)
+	}
+	if err := os.WriteFile(d.ResolveStorePath(kernelFileName), []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.verifyBootFiles(); err == nil {
+		t.Error("Wanted an error verifying a changed kernel")
+	}
+}
+
+func TestFetchFile_Download(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/slow":
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	defer func(client *http.Client) { downloadClient = client }(downloadClient)
+	downloadClient = &http.Client{Timeout: 100 * time.Millisecond}
+
+	tests := []struct {
+		msg  string
+		path string
+	}{
+		{
+			msg:  "error status",
+			path: "/missing",
+		}, {
+			msg:  "timeout",
+			path: "/slow",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), initrdFileName)
+			if _, err := fetchFile(server.URL+tt.path, dest); err == nil {
+				t.Error("Wanted an error downloading")
+			}
+			if _, err := os.Stat(dest); !os.IsNotExist(err) {
+				t.Errorf("Wanted nothing to be left at %s but got %v", dest, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package driver
+
+import (
+	"bytes"
+	"os"
+	"strings"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/iso9660"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// seedFileName is the NoCloud seed image of cloud-init machines, which
+	// cloud-init finds by its volume label.
+	seedFileName    = "seed.iso"
+	seedVolumeLabel = "cidata"
+
+	cloudConfigHeader = "#cloud-config\n"
+)
+
+// readCloudConfig reads the cloud-config given with --vz-cloud-config.
+func readCloudConfig(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read cloud-config")
+	}
+	if !bytes.HasPrefix(data, []byte(cloudConfigHeader)) {
+		return nil, errors.Errorf("%s is not a cloud-config, which starts with %q", path, strings.TrimSpace(cloudConfigHeader))
+	}
+
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse %s", path)
+	}
+	return config, nil
+}
+
+// cloudInitUserData renders the user-data of the machine, which sets its
+// hostname and creates the SSH user with publicKey, merged with the user's
+// --vz-cloud-config.
+func (d *Driver) cloudInitUserData(publicKey string) ([]byte, error) {
+	config := map[string]interface{}{
+		"hostname": d.MachineName,
+		"users": []interface{}{
+			map[string]interface{}{
+				"name":                d.GetSSHUsername(),
+				"sudo":                "ALL=(ALL) NOPASSWD:ALL",
+				"shell":               "/bin/bash",
+				"ssh_authorized_keys": []interface{}{strings.TrimSpace(publicKey)},
+			},
+		},
+	}
+
+	if d.CloudConfig != "" {
+		userConfig, err := readCloudConfig(d.CloudConfig)
+		if err != nil {
+			return nil, err
+		}
+		mergeConfig(config, userConfig)
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(cloudConfigHeader), data...), nil
+}
+
+// cloudInitMetaData renders the meta-data of the machine. The instance ID
+// stays the same for the life of the machine, so that cloud-init only
+// provisions it on first boot.
+func (d *Driver) cloudInitMetaData() ([]byte, error) {
+	return yaml.Marshal(map[string]string{
+		"instance-id":    "iid-" + d.MachineName,
+		"local-hostname": d.MachineName,
+	})
+}
+
+// createCloudInitSeed writes the NoCloud seed image of the machine.
+func (d *Driver) createCloudInitSeed() error {
+	publicKey, err := os.ReadFile(publicSSHKeyPath(d.BaseDriver))
+	if err != nil {
+		return err
+	}
+	userData, err := d.cloudInitUserData(string(publicKey))
+	if err != nil {
+		return err
+	}
+	metaData, err := d.cloudInitMetaData()
+	if err != nil {
+		return err
+	}
+
+	seed := iso9660.NewWriter(seedVolumeLabel)
+	if err := seed.AddFile("user-data", userData); err != nil {
+		return err
+	}
+	if err := seed.AddFile("meta-data", metaData); err != nil {
+		return err
+	}
+
+	seedPath := d.ResolveStorePath(seedFileName)
+	log.Debugf("Writing cloud-init seed image %s", seedPath)
+	f, err := os.OpenFile(seedPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := seed.WriteTo(f); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Failed to write %s", seedPath)
+	}
+	return f.Close()
+}
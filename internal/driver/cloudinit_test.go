@@ -0,0 +1,114 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestDriver_cloudInitUserData(t *testing.T) {
+	const publicKey = "ssh-ed25519 AAAA test\n"
+	user := map[string]interface{}{
+		"name":                defaultSSHUser,
+		"sudo":                "ALL=(ALL) NOPASSWD:ALL",
+		"shell":               "/bin/bash",
+		"ssh_authorized_keys": []interface{}{"ssh-ed25519 AAAA test"},
+	}
+
+	tests := []struct {
+		msg         string
+		cloudConfig string
+		want        map[string]interface{}
+		wantErr     bool
+	}{
+		{
+			msg: "driver config",
+			want: map[string]interface{}{
+				"hostname": "test",
+				"users":    []interface{}{user},
+			},
+		}, {
+			msg: "merged cloud-config",
+			cloudConfig: `#cloud-config
+hostname: dev
+users:
+  - name: admin
+packages: [git]
+write_files:
+  - path: /etc/motd
+    content: hello
+`,
+			want: map[string]interface{}{
+				"hostname":    "dev",
+				"users":       []interface{}{user, map[string]interface{}{"name": "admin"}},
+				"packages":    []interface{}{"git"},
+				"write_files": []interface{}{map[string]interface{}{"path": "/etc/motd", "content": "hello"}},
+			},
+		}, {
+			msg:         "not a cloud-config",
+			cloudConfig: "#!/bin/sh\necho hello\n",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			d := newTestDriver(t)
+			d.Provisioning = ProvisionCloudInit
+			if tt.cloudConfig != "" {
+				d.CloudConfig = filepath.Join(t.TempDir(), "cloud-config.yaml")
+				if err := os.WriteFile(d.CloudConfig, []byte(tt.cloudConfig), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data, err := d.cloudInitUserData(publicKey)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Wanted an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error rendering user-data: %s", err)
+			}
+
+			if string(data[:len(cloudConfigHeader)]) != cloudConfigHeader {
+				t.Errorf("Wanted user-data to start with %q but got %q", cloudConfigHeader, data)
+			}
+			got := map[string]interface{}{}
+			if err := yaml.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("Wanted user-data %v but got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDriver_generateVmConfigCloudInit(t *testing.T) {
+	d := newTestDriver(t)
+	d.Provisioning = ProvisionCloudInit
+	if err := os.WriteFile(publicSSHKeyPath(d.BaseDriver), []byte("ssh-ed25519 AAAA test\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.createCloudInitSeed(); err != nil {
+		t.Fatalf("Unexpected error creating seed image: %s", err)
+	}
+
+	config, err := d.generateVmConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error generating config: %s", err)
+	}
+	seed := config.Disks[len(config.Disks)-1]
+	if seed.Path != d.ResolveStorePath(seedFileName) || !seed.ReadOnly {
+		t.Errorf("Wanted the seed image attached read-only last but got %+v", config.Disks)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Unexpected error validating config: %s", err)
+	}
+}
@@ -0,0 +1,169 @@
+package driver
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// kernelArg is a single kernel command line argument, either a flag such
+// as "quiet" or a key=value parameter. A quoted value keeps its quotes.
+type kernelArg struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+func parseKernelArg(s string) kernelArg {
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		return kernelArg{key: s[:i], value: s[i+1:], hasValue: true}
+	}
+	return kernelArg{key: s}
+}
+
+func (a kernelArg) String() string {
+	if !a.hasValue {
+		return a.key
+	}
+	return a.key + "=" + a.value
+}
+
+// sameKernelParameter reports whether a and b name the same parameter,
+// which the kernel doesn't distinguish by dashes and underscores.
+func sameKernelParameter(a, b string) bool {
+	return strings.ReplaceAll(a, "-", "_") == strings.ReplaceAll(b, "-", "_")
+}
+
+// kernelCmdLine is a kernel command line in which every parameter appears
+// once. Arguments merged in later replace earlier ones with the same key
+// and move to the end, so that the last source wins both the value and the
+// position, which matters e.g. for console=.
+type kernelCmdLine struct {
+	args []kernelArg
+	// init are the arguments after "--", which the kernel passes to init
+	// as they are.
+	init []string
+}
+
+// splitKernelArgs splits s at whitespace outside of double quotes.
+func splitKernelArgs(s string) []string {
+	var args []string
+	var arg strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			arg.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if arg.Len() > 0 {
+				args = append(args, arg.String())
+				arg.Reset()
+			}
+		default:
+			arg.WriteRune(r)
+		}
+	}
+	if arg.Len() > 0 {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+// merge adds the arguments of the command line s.
+func (c *kernelCmdLine) merge(s string) {
+	args := splitKernelArgs(s)
+	for i, arg := range args {
+		if arg == "--" {
+			c.init = append(c.init, args[i+1:]...)
+			return
+		}
+		c.set(parseKernelArg(arg))
+	}
+}
+
+// set adds arg, replacing any argument with the same key.
+func (c *kernelCmdLine) set(arg kernelArg) {
+	c.remove(arg.key)
+	c.args = append(c.args, arg)
+}
+
+// remove removes the arguments with key.
+func (c *kernelCmdLine) remove(key string) {
+	args := c.args[:0]
+	for _, arg := range c.args {
+		if !sameKernelParameter(arg.key, key) {
+			args = append(args, arg)
+		}
+	}
+	c.args = args
+}
+
+// apply merges extra, a command line in which "-<key>" removes key instead
+// of adding it.
+func (c *kernelCmdLine) apply(extra string) error {
+	args := splitKernelArgs(extra)
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			c.init = append(c.init, args[i+1:]...)
+			return nil
+		case strings.HasPrefix(arg, "-"):
+			key := strings.TrimPrefix(arg, "-")
+			if key == "" || strings.ContainsRune(key, '=') {
+				return errors.Errorf("Invalid kernel argument removal %q, must be -<key>", arg)
+			}
+			c.remove(key)
+		default:
+			c.set(parseKernelArg(arg))
+		}
+	}
+	return nil
+}
+
+func (c *kernelCmdLine) String() string {
+	args := make([]string, 0, len(c.args)+1+len(c.init))
+	for _, arg := range c.args {
+		args = append(args, arg.String())
+	}
+	if len(c.init) > 0 {
+		args = append(args, "--")
+		args = append(args, c.init...)
+	}
+	return strings.Join(args, " ")
+}
+
+// maxKernelCmdLine is the size of the kernel's command line buffer,
+// COMMAND_LINE_SIZE, including its terminating NUL.
+const maxKernelCmdLine = 2048
+
+// kernelCmdLine renders the kernel command line of the machine from the
+// driver's base options, those of the ISO, the console, those of Ignition
+// and the user's --vz-kernel-args, each winning over the ones before.
+// firstBoot selects the command line of the first boot of the machine,
+// which provisions it.
+func (d *Driver) kernelCmdLine(firstBoot bool) (string, error) {
+	var cmdLine kernelCmdLine
+	cmdLine.merge(baseCmdLineOptions)
+	cmdLine.merge(d.Cmdline)
+	cmdLine.merge(consoleCmdLineOption)
+	if d.provisioning() == ProvisionIgnition {
+		args, err := d.ignitionKernelArgs(firstBoot)
+		if err != nil {
+			return "", err
+		}
+		cmdLine.merge(args)
+	}
+	for _, extra := range d.KernelArgs {
+		if err := cmdLine.apply(extra); err != nil {
+			return "", err
+		}
+	}
+
+	s := cmdLine.String()
+	if len(s) >= maxKernelCmdLine {
+		return "", errors.Errorf("Kernel command line of %d bytes is longer than the %d the kernel accepts", len(s), maxKernelCmdLine-1)
+	}
+	return s, nil
+}
@@ -0,0 +1,101 @@
+package driver
+
+import "testing"
+
+func TestKernelCmdLine(t *testing.T) {
+	tests := []struct {
+		msg     string
+		sources []string
+		extras  []string
+		want    string
+		wantErr bool
+	}{
+		{
+			msg:     "later sources win",
+			sources: []string{"irqaffinity=0 quiet", "loglevel=3 irqaffinity=1", "console=hvc0"},
+			want:    "quiet loglevel=3 irqaffinity=1 console=hvc0",
+		}, {
+			msg:     "duplicates within a source",
+			sources: []string{"loglevel=3 loglevel=7 quiet quiet"},
+			want:    "loglevel=7 quiet",
+		}, {
+			msg:     "dashes and underscores",
+			sources: []string{"module_blacklist=vboxsf", "module-blacklist=vboxguest"},
+			want:    "module-blacklist=vboxguest",
+		}, {
+			msg:     "quoted values",
+			sources: []string{`dyndbg="file drivers/usb/* +p" quiet`},
+			want:    `dyndbg="file drivers/usb/* +p" quiet`,
+		}, {
+			msg:     "init arguments",
+			sources: []string{"quiet -- single", "console=hvc0"},
+			want:    "quiet console=hvc0 -- single",
+		}, {
+			msg:     "extras add, override and remove",
+			sources: []string{"irqaffinity=0 module_blacklist=vboxguest,vboxsf", "console=hvc0"},
+			extras:  []string{"-module_blacklist", "console=ttyS0 debug"},
+			want:    "irqaffinity=0 console=ttyS0 debug",
+		}, {
+			msg:     "invalid removal",
+			extras:  []string{"-console=hvc0"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var cmdLine kernelCmdLine
+			for _, source := range tt.sources {
+				cmdLine.merge(source)
+			}
+			for _, extra := range tt.extras {
+				if err := cmdLine.apply(extra); err != nil {
+					if !tt.wantErr {
+						t.Fatalf("Unexpected error %q", err)
+					}
+					return
+				}
+			}
+			if tt.wantErr {
+				t.Fatalf("Wanted an error but got %q", cmdLine.String())
+			}
+			if got := cmdLine.String(); got != tt.want {
+				t.Errorf("Wanted %q but got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDriver_kernelCmdLine(t *testing.T) {
+	tests := []struct {
+		msg        string
+		kernelArgs []string
+		want       string
+	}{
+		{
+			msg:  "defaults",
+			want: "irqaffinity=0 module_blacklist=vboxguest,vboxsf loglevel=3 console=hvc0",
+		}, {
+			msg:        "default removed",
+			kernelArgs: []string{"-module_blacklist"},
+			want:       "irqaffinity=0 loglevel=3 console=hvc0",
+		}, {
+			msg:        "default overridden",
+			kernelArgs: []string{"module-blacklist=vboxsf"},
+			want:       "irqaffinity=0 loglevel=3 console=hvc0 module-blacklist=vboxsf",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			d := newTestDriver(t)
+			d.KernelArgs = tt.kernelArgs
+
+			got, err := d.kernelCmdLine(false)
+			if err != nil {
+				t.Fatalf("Unexpected error %q", err)
+			}
+			if got != tt.want {
+				t.Errorf("Wanted %q but got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -1,6 +1,3 @@
-//go:build darwin
-// +build darwin
-
 /*
 Copyright 2016 The Kubernetes Authors All rights reserved.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -17,18 +14,19 @@ limitations under the License.
 package driver
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/brholstein/docker-machine-driver-vz/internal/control"
+	"github.com/brholstein/docker-machine-driver-vz/internal/iso9660"
 	vznet "github.com/brholstein/docker-machine-driver-vz/internal/net"
 	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
 
@@ -45,19 +43,37 @@ import (
 )
 
 const (
-	defaultCPU            = 1
-	defaultMemory         = 1024
-	defaultDiskSize       = 20000
-	defaultBoot2DockerURL = ""
+	defaultCPU             = 1
+	defaultMemory          = 1024
+	defaultDiskSize        = 20000
+	defaultStopTimeout     = 30
+	defaultConsoleLogSize  = 10
+	defaultConsoleLogFiles = 3
+	defaultBoot2DockerURL  = ""
+
+	// launcherReadyTimeout bounds how long Start waits for vz to report
+	// whether the VM started.
+	launcherReadyTimeout = 2 * time.Minute
 
 	defaultSSHUser = "docker"
 
-	isoFileName  = "boot2docker.iso"
-	isoMountPath = "b2d-image"
+	isoFileName = "boot2docker.iso"
 
+	// baseCmdLineOptions are the defaults of the kernel command line. The
+	// VirtualBox guest modules of boot2docker are blacklisted as they have
+	// no device to drive, which --vz-kernel-args -module_blacklist undoes.
 	baseCmdLineOptions = "irqaffinity=0 module_blacklist=vboxguest,vboxsf"
 
-	pidFileName = "vz.pid"
+	pidFileName           = "vz.pid"
+	configFileName        = "vz.json"
+	consoleSocketFileName = "console.sock"
+	consoleLogFileName    = "console.log"
+
+	efiVariableStoreFileName = "efi-variable-store"
+
+	// consoleCmdLineOption makes the virtio console the kernel console. It
+	// is appended last, as the last console= option is /dev/console.
+	consoleCmdLineOption = "console=hvc0"
 )
 
 var (
@@ -72,15 +88,91 @@ type Driver struct {
 	Memory   uint
 	DiskSize uint
 
+	// MemoryBalloon allows vz memory to change the memory of the running
+	// guest, up to Memory.
+	MemoryBalloon bool
+
 	Boot2DockerURL string
 
+	// Provisioning is how the guest is set up on first boot. Machines
+	// provisioned by cloud-init or Ignition boot the raw image DiskImage, a
+	// path or URL, with the cloud-config CloudConfig or the Ignition config
+	// IgnitionConfig merged into the one of the driver.
+	Provisioning     Provisioning
+	DiskImage        string
+	CloudConfig      string
+	IgnitionConfig   string
+	IgnitionDelivery IgnitionDelivery
+
+	// firstBoot is set while Create starts the machine for the first time.
+	firstBoot bool
+
+	// BootMode is linux to boot the kernel and initrd extracted from the
+	// ISO, or efi to boot the ISO through EFI firmware.
+	BootMode vz.BootMode
+
+	// Kernel and Initrd are the names of the files booted in the machine
+	// directory, and Cmdline the command line options of the ISO, unless
+	// given with --vz-cmdline.
 	Initrd  string
 	Kernel  string
 	Cmdline string
 
+	// KernelSource and InitrdSource are the paths or URLs given with
+	// --vz-kernel and --vz-initrd, with the checksums of the files copied
+	// from them.
+	KernelSource   string
+	KernelChecksum string
+	InitrdSource   string
+	InitrdChecksum string
+
+	// KernelArgs are the --vz-kernel-args, merged into the kernel command
+	// line last. KernelCmdLine is the command line the machine was last
+	// started with, kept for inspection.
+	KernelArgs    []string
+	KernelCmdLine string
+
 	ShareDirectory bool
 
-	MACAddress vznet.HardwareAddr
+	// PortForwards are "[tcp:|unix:]<address>:<guest port>" specs of host
+	// addresses relayed to guest ports by vz-agent.
+	PortForwards []string
+
+	// RestartPolicy is when vz restarts the VM after it stopped by itself,
+	// at most RestartMaxRetries times in a row if it is not 0.
+	RestartPolicy     vz.RestartPolicyMode
+	RestartMaxRetries uint
+
+	// WatchdogTimeout is how many seconds vz waits for a heartbeat from
+	// vz-agent before marking the guest unhealthy, and stopping and
+	// starting it again if WatchdogRestart is set. 0 disables the watchdog.
+	WatchdogTimeout uint
+	WatchdogRestart bool
+
+	// MetricsAddress is where vz serves Prometheus metrics, as
+	// unix:<path> or [<host>:]<port>. Metrics are disabled if it is empty.
+	MetricsAddress string
+
+	// StopTimeout is how many seconds Stop waits for each step of a
+	// shutdown before escalating.
+	StopTimeout uint
+
+	// ConsoleLogSize is the size in MiB at which console.log is rotated,
+	// keeping ConsoleLogFiles previous generations. Both are unset for
+	// machines created before console logs were kept, ConsoleLogFiles
+	// being nil as keeping no previous generation is valid.
+	ConsoleLogSize  uint
+	ConsoleLogFiles *uint
+
+	// NetworkInterfaces are the machine's network interfaces, with the MAC
+	// addresses they were assigned on first start.
+	NetworkInterfaces []vz.VirtualMachineNetworkInterface
+
+	// MACAddress and BridgeInterface describe the single network interface
+	// of machines created before NetworkInterfaces. They are moved there
+	// on the next start.
+	MACAddress      vznet.HardwareAddr
+	BridgeInterface string
 }
 
 func NewDriver(hostname, storePath string) drivers.Driver {
@@ -96,10 +188,37 @@ func NewDriver(hostname, storePath string) drivers.Driver {
 		Boot2DockerURL: defaultBoot2DockerURL,
 
 		ShareDirectory: true,
+		StopTimeout:    defaultStopTimeout,
+
+		ConsoleLogSize: defaultConsoleLogSize,
 	}
 }
 
 func (d *Driver) PreCreateCheck() error {
+	if err := checkBootSource("--vz-kernel", d.KernelSource); err != nil {
+		return err
+	}
+	if err := checkBootSource("--vz-initrd", d.InitrdSource); err != nil {
+		return err
+	}
+	if err := checkBootSource("--vz-disk-image", d.DiskImage); err != nil {
+		return err
+	}
+	if d.CloudConfig != "" {
+		if _, err := readCloudConfig(d.CloudConfig); err != nil {
+			return err
+		}
+	}
+	if d.IgnitionConfig != "" {
+		if _, err := readIgnitionConfig(d.IgnitionConfig); err != nil {
+			return err
+		}
+	}
+
+	if !d.usesISO() {
+		return nil
+	}
+
 	// Downloading boot2docker to cache should be done here to make sure
 	// that a download failure will not leave a machine half created.
 	b2dutils := mcnutils.NewB2dUtils(d.StorePath)
@@ -114,15 +233,25 @@ func (d *Driver) PreCreateCheck() error {
 func (d *Driver) Create() error {
 	log.Info("Creating the VM preamble...")
 
-	//TODO(r2d4): rewrite this, not using b2dutils
-	b2dutils := mcnutils.NewB2dUtils(d.StorePath)
-	if err := b2dutils.CopyIsoToMachineDir(d.Boot2DockerURL, d.MachineName); err != nil {
-		return errors.Wrap(err, "Error copying ISO to machine dir")
+	if d.usesISO() {
+		//TODO(r2d4): rewrite this, not using b2dutils
+		b2dutils := mcnutils.NewB2dUtils(d.StorePath)
+		if err := b2dutils.CopyIsoToMachineDir(d.Boot2DockerURL, d.MachineName); err != nil {
+			return errors.Wrap(err, "Error copying ISO to machine dir")
+		}
 	}
 
-	log.Info("Extracting kernel...")
-	if err := d.extractKernel(); err != nil {
-		return errors.Wrap(err, "extracting kernel")
+	if err := d.fetchBootFiles(); err != nil {
+		return err
+	}
+
+	// EFI firmware boots the ISO itself, and there is nothing to extract
+	// when the user gave all of the kernel, initrd and command line.
+	if d.BootMode != vz.BootModeEFI && (d.Kernel == "" || d.Initrd == "" || d.Cmdline == "") && d.usesISO() {
+		log.Info("Extracting kernel...")
+		if err := d.extractKernel(); err != nil {
+			return errors.Wrap(err, "extracting kernel")
+		}
 	}
 
 	log.Info("Creating ssh key...")
@@ -130,9 +259,35 @@ func (d *Driver) Create() error {
 		return errors.Wrap(err, "creating ssh key")
 	}
 
-	log.Info("Creating raw disk image...")
-	if err := createRawDiskImage(publicSSHKeyPath(d.BaseDriver), GetDiskPath(d.BaseDriver), d.DiskSize); err != nil {
-		return errors.Wrap(err, "creating disk image")
+	d.firstBoot = true
+	defer func() { d.firstBoot = false }()
+
+	switch d.provisioning() {
+	case ProvisionCloudInit:
+		log.Info("Creating disk image...")
+		if err := d.createDisk(); err != nil {
+			return errors.Wrap(err, "creating disk image")
+		}
+
+		log.Info("Creating cloud-init seed image...")
+		if err := d.createCloudInitSeed(); err != nil {
+			return errors.Wrap(err, "creating cloud-init seed image")
+		}
+	case ProvisionIgnition:
+		log.Info("Creating disk image...")
+		if err := d.createDisk(); err != nil {
+			return errors.Wrap(err, "creating disk image")
+		}
+
+		log.Info("Creating Ignition config...")
+		if err := d.createIgnitionConfig(); err != nil {
+			return errors.Wrap(err, "creating Ignition config")
+		}
+	default:
+		log.Info("Creating raw disk image...")
+		if err := createRawDiskImage(publicSSHKeyPath(d.BaseDriver), GetDiskPath(d.BaseDriver), d.DiskSize); err != nil {
+			return errors.Wrap(err, "creating disk image")
+		}
 	}
 
 	// Must start VM as part of creation.
@@ -160,6 +315,11 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Size of memory for host VM (in MB)",
 			Value:  defaultMemory,
 		},
+		mcnflag.BoolFlag{
+			EnvVar: "VZ_MEMORY_BALLOON",
+			Name:   "vz-memory-balloon",
+			Usage:  "Allow the memory of the running VM to be reduced below its memory size with 'vz memory'",
+		},
 		mcnflag.IntFlag{
 			EnvVar: "VZ_DISK_SIZE",
 			Name:   "vz-disk-size",
@@ -174,10 +334,131 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Value:  "",
 		},
 
+		mcnflag.StringFlag{
+			EnvVar: "VZ_PROVISIONING",
+			Name:   "vz-provisioning",
+			Usage:  "How the guest is set up on first boot: boot2docker, cloud-init or ignition",
+			Value:  string(ProvisionBoot2Docker),
+		},
+		mcnflag.StringFlag{
+			EnvVar: "VZ_DISK_IMAGE",
+			Name:   "vz-disk-image",
+			Usage:  "Path or URL of a raw disk image to create the disk from, with cloud-init or ignition provisioning",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "VZ_CLOUD_CONFIG",
+			Name:   "vz-cloud-config",
+			Usage:  "Path of a cloud-config to merge into the user-data of cloud-init machines",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "VZ_IGNITION_CONFIG",
+			Name:   "vz-ignition-config",
+			Usage:  "Path of an Ignition v3 config to merge into the one of ignition machines",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "VZ_IGNITION_DELIVERY",
+			Name:   "vz-ignition-delivery",
+			Usage:  "How the Ignition config reaches the guest: disk (default) or cmdline",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "VZ_BOOT_MODE",
+			Name:   "vz-boot-mode",
+			Usage:  "Boot the kernel extracted from the ISO (linux), or the ISO itself through EFI firmware (efi)",
+			Value:  string(vz.BootModeLinux),
+		},
+
+		mcnflag.StringFlag{
+			EnvVar: "VZ_KERNEL",
+			Name:   "vz-kernel",
+			Usage:  "Path or URL of a kernel to boot instead of the ISO's",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "VZ_INITRD",
+			Name:   "vz-initrd",
+			Usage:  "Path or URL of an initial ramdisk to boot instead of the ISO's",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "VZ_CMDLINE",
+			Name:   "vz-cmdline",
+			Usage:  "Kernel command line options to use instead of the ISO's",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "VZ_KERNEL_ARGS",
+			Name:   "vz-kernel-args",
+			Usage:  "Add or override kernel command line arguments, or remove inherited ones with -<key>",
+		},
+
 		mcnflag.BoolFlag{
 			Name:  "vz-no-share-directory",
 			Usage: "Disable the mount of your home directory",
 		},
+
+		mcnflag.StringFlag{
+			EnvVar: "VZ_BRIDGE_INTERFACE",
+			Name:   "vz-bridge-interface",
+			Usage:  "Bridge the VM to this host interface (e.g. en0) instead of using the NAT network",
+		},
+
+		mcnflag.StringSliceFlag{
+			EnvVar: "VZ_NETWORK",
+			Name:   "vz-network",
+			Usage:  "Add a network interface, as nat, bridged:<host interface> or file-handle:<datagram socket>",
+		},
+
+		mcnflag.StringSliceFlag{
+			EnvVar: "VZ_PORT_FORWARD",
+			Name:   "vz-port-forward",
+			Usage:  "Forward a host address to a guest port over vsock, as [tcp:|unix:]<address>:<guest port>",
+		},
+
+		mcnflag.StringFlag{
+			EnvVar: "VZ_RESTART_POLICY",
+			Name:   "vz-restart-policy",
+			Usage:  "Restart the VM when it stops by itself: no, on-failure or always",
+			Value:  string(vz.RestartNo),
+		},
+		mcnflag.IntFlag{
+			EnvVar: "VZ_RESTART_MAX_RETRIES",
+			Name:   "vz-restart-max-retries",
+			Usage:  "Maximum number of restarts by the restart policy (0 for no limit)",
+		},
+
+		mcnflag.IntFlag{
+			EnvVar: "VZ_WATCHDOG_TIMEOUT",
+			Name:   "vz-watchdog-timeout",
+			Usage:  "Seconds without a heartbeat from the guest before it is marked unhealthy (0 to disable the watchdog)",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "VZ_WATCHDOG_RESTART",
+			Name:   "vz-watchdog-restart",
+			Usage:  "Stop and start the VM when the watchdog marks the guest unhealthy",
+		},
+
+		mcnflag.StringFlag{
+			EnvVar: "VZ_METRICS_ADDRESS",
+			Name:   "vz-metrics-address",
+			Usage:  "Serve Prometheus metrics of the VM on unix:<path> or [<host>:]<port>",
+		},
+
+		mcnflag.IntFlag{
+			EnvVar: "VZ_STOP_TIMEOUT",
+			Name:   "vz-stop-timeout",
+			Usage:  "Seconds to wait for the VM to stop before escalating to a forced stop",
+			Value:  defaultStopTimeout,
+		},
+
+		mcnflag.IntFlag{
+			EnvVar: "VZ_CONSOLE_LOG_SIZE",
+			Name:   "vz-console-log-size",
+			Usage:  "Size of the serial console log before it is rotated (in MB)",
+			Value:  defaultConsoleLogSize,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "VZ_CONSOLE_LOG_FILES",
+			Name:   "vz-console-log-files",
+			Usage:  "Number of rotated serial console logs to keep (0 for none)",
+			Value:  defaultConsoleLogFiles,
+		},
 	}
 }
 
@@ -192,9 +473,28 @@ func (d *Driver) GetIP() (string, error) {
 		return d.IPAddress, nil
 	}
 
+	network, ok := d.endpointInterface()
+	if !ok {
+		return "", errors.New("No NAT or bridged network interface to reach the machine through")
+	}
+	mac := network.MACAddress.ToNetHardwareAddr().String()
+
+	// Bridged machines get their address from the network they are bridged
+	// to, which doesn't show up in the leases of the NAT network.
+	source := "dhcp leases file"
+	lookup := func() (string, error) {
+		return GetIPAddressByMACAddress(mac)
+	}
+	if network.Attachment() == vz.NetworkBridged {
+		source = "ARP table of " + network.BridgeInterface
+		lookup = func() (string, error) {
+			return GetIPAddressByMACAddressFromARP(mac, network.BridgeInterface)
+		}
+	}
+
 	getIP := func() bool {
 		var err error
-		d.IPAddress, err = GetIPAddressByMACAddress(d.getMacAddress().String())
+		d.IPAddress, err = lookup()
 		if err != nil {
 			log.Debug(err)
 			return false
@@ -203,7 +503,7 @@ func (d *Driver) GetIP() (string, error) {
 	}
 
 	if err := mcnutils.WaitForSpecific(getIP, 30, 2*time.Second); err != nil {
-		return "", errors.Wrap(err, "IP address not found in dhcp leases file")
+		return "", errors.Wrapf(err, "IP address not found in %s", source)
 	}
 
 	return d.IPAddress, nil
@@ -218,6 +518,9 @@ func (d *Driver) GetSSHHostname() (string, error) {
 func (d *Driver) GetSSHUsername() string {
 	if d.SSHUser == "" {
 		d.SSHUser = defaultSSHUser
+		if d.provisioning() == ProvisionIgnition {
+			d.SSHUser = defaultIgnitionSSHUser
+		}
 	}
 
 	return d.SSHUser
@@ -228,9 +531,26 @@ func (d *Driver) GetState() (state.State, error) {
 	pid := d.getPid()
 
 	if pid == 0 {
+		// The launcher is gone, report how it left the machine.
+		if exit, err := d.GetLastExit(); err == nil && exit != nil && exit.Reason.IsFailure() {
+			return state.Error, nil
+		}
 		return state.Stopped, nil
 	}
 
+	stateName, err := d.controlClient().State()
+	if err == nil {
+		d.logRestarts()
+		if health, err := d.GetHealth(); err == nil && health == vz.HealthUnhealthy {
+			log.Warnf("%s is running, but its guest stopped sending heartbeats", d.MachineName)
+		}
+		return machineState(stateName), nil
+	}
+	if !errors.Is(err, control.ErrUnavailable) {
+		return state.Error, err
+	}
+	log.Debugf("Unable to query vz for its state: %s", err)
+
 	proc, err := ps.FindProcess(pid)
 	if err != nil {
 		return state.Error, err
@@ -246,7 +566,64 @@ func (d *Driver) GetState() (state.State, error) {
 		return state.Error, nil
 	}
 
-	return state.Running, nil
+	status, err := d.readStateFile()
+	if err != nil || status.Pid != pid {
+		return state.Running, nil
+	}
+
+	return machineState(status.State), nil
+}
+
+// logRestarts logs the restarts vz did according to the restart policy.
+func (d *Driver) logRestarts() {
+	status, err := d.readStateFile()
+	if err != nil {
+		return
+	}
+	for _, restart := range status.Restarts {
+		message := fmt.Sprintf("vz restarted %s after %ds (attempt %d) at %s, as it stopped with %s",
+			d.MachineName, restart.Backoff, restart.Attempt, restart.Exit.Timestamp.Format(time.RFC3339), restart.Exit.Reason)
+		if restart.Exit.Error != "" {
+			message += ": " + restart.Exit.Error
+		}
+		log.Debug(message)
+	}
+}
+
+// GetLastExit returns how the machine last stopped, or nil if it never did.
+func (d *Driver) GetLastExit() (*vz.Exit, error) {
+	status, err := d.readStateFile()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return status.LastExit, nil
+}
+
+// GetHealth returns the health of the guest as tracked by the watchdog,
+// separately from the state of the machine. It is empty if the watchdog is
+// disabled or the machine is not running.
+func (d *Driver) GetHealth() (vz.Health, error) {
+	if d.WatchdogTimeout == 0 || d.getPid() == 0 {
+		return "", nil
+	}
+
+	status, err := d.readStateFile()
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return status.Health, nil
+}
+
+func (d *Driver) readStateFile() (*vz.Status, error) {
+	return vz.ReadStateFile(d.ResolveStorePath(vz.StateFileName))
 }
 
 // GetURL returns a Docker compatible host URL for connecting to this host
@@ -266,11 +643,21 @@ func (d *Driver) GetURL() (string, error) {
 
 // Kill stops a host forcefully
 func (d *Driver) Kill() error {
-	return d.sendSignal(os.Kill)
+	err := d.controlClient().ForceStop()
+	if errors.Is(err, control.ErrUnavailable) {
+		log.Debugf("Unable to reach vz, sending signal instead: %s", err)
+		return d.sendSignal(os.Kill)
+	}
+
+	return err
 }
 
 // Remove a host
 func (d *Driver) Remove() error {
+	if drivers.MachineInState(d, state.Paused)() {
+		return d.Kill()
+	}
+
 	if !drivers.MachineInState(d, state.Running)() {
 		return nil
 	}
@@ -299,37 +686,176 @@ func (d *Driver) SetConfigFromFlags(opts drivers.DriverOptions) error {
 	d.CPU = uint(opts.Int("vz-cpu-count"))
 	d.Memory = uint(opts.Int("vz-memory-size"))
 	d.DiskSize = uint(opts.Int("vz-disk-size"))
+	d.MemoryBalloon = opts.Bool("vz-memory-balloon")
 
 	d.Boot2DockerURL = opts.String("vz-boot2docker-url")
 
+	d.BootMode = vz.BootMode(opts.String("vz-boot-mode"))
+	switch d.BootMode {
+	case vz.BootModeLinux, vz.BootModeEFI:
+	default:
+		return errors.Errorf("Invalid boot mode %q, must be %s or %s", d.BootMode, vz.BootModeLinux, vz.BootModeEFI)
+	}
+
+	d.KernelSource = opts.String("vz-kernel")
+	d.InitrdSource = opts.String("vz-initrd")
+	d.Cmdline = opts.String("vz-cmdline")
+	d.KernelArgs = opts.StringSlice("vz-kernel-args")
+	if d.BootMode == vz.BootModeEFI {
+		for _, flag := range []struct {
+			name string
+			set  bool
+		}{
+			{"--vz-kernel", d.KernelSource != ""},
+			{"--vz-initrd", d.InitrdSource != ""},
+			{"--vz-cmdline", d.Cmdline != ""},
+			{"--vz-kernel-args", len(d.KernelArgs) > 0},
+		} {
+			if flag.set {
+				return errors.Errorf("%s is not supported in %s boot mode", flag.name, vz.BootModeEFI)
+			}
+		}
+	}
+
+	d.Provisioning = Provisioning(opts.String("vz-provisioning"))
+	d.DiskImage = opts.String("vz-disk-image")
+	d.CloudConfig = opts.String("vz-cloud-config")
+	d.IgnitionConfig = opts.String("vz-ignition-config")
+	d.IgnitionDelivery = IgnitionDelivery(opts.String("vz-ignition-delivery"))
+	if err := d.checkProvisioning(); err != nil {
+		return err
+	}
+	// The command line depends on the provisioning, so it is only checked
+	// once that is known.
+	if _, err := d.kernelCmdLine(false); err != nil {
+		return err
+	}
+
 	d.ShareDirectory = !opts.Bool("vz-no-share-directory")
 
+	d.NetworkInterfaces = nil
+	for _, spec := range opts.StringSlice("vz-network") {
+		network, err := vz.ParseNetworkInterface(spec)
+		if err != nil {
+			return err
+		}
+		d.NetworkInterfaces = append(d.NetworkInterfaces, network)
+	}
+	if bridgeInterface := opts.String("vz-bridge-interface"); bridgeInterface != "" {
+		if len(d.NetworkInterfaces) > 0 {
+			return errors.New("Use either --vz-bridge-interface or --vz-network, not both")
+		}
+		d.NetworkInterfaces = append(d.NetworkInterfaces, vz.VirtualMachineNetworkInterface{
+			Type:            vz.NetworkBridged,
+			BridgeInterface: bridgeInterface,
+		})
+	}
+	for _, network := range d.NetworkInterfaces {
+		if network.Attachment() != vz.NetworkBridged {
+			continue
+		}
+		if err := checkBridgeInterface(network.BridgeInterface); err != nil {
+			return err
+		}
+	}
+	d.networkInterfaces()
+
+	d.PortForwards = opts.StringSlice("vz-port-forward")
+	for _, forward := range d.PortForwards {
+		if _, err := vz.ParsePortForward(forward); err != nil {
+			return err
+		}
+	}
+
+	d.RestartPolicy = vz.RestartPolicyMode(opts.String("vz-restart-policy"))
+	switch d.RestartPolicy {
+	case vz.RestartNo, vz.RestartOnFailure, vz.RestartAlways:
+	default:
+		return errors.Errorf("Invalid restart policy %q, must be %s, %s or %s", d.RestartPolicy, vz.RestartNo, vz.RestartOnFailure, vz.RestartAlways)
+	}
+	d.RestartMaxRetries = uint(opts.Int("vz-restart-max-retries"))
+
+	d.WatchdogTimeout = uint(opts.Int("vz-watchdog-timeout"))
+	d.WatchdogRestart = opts.Bool("vz-watchdog-restart")
+	if d.WatchdogRestart && d.WatchdogTimeout == 0 {
+		return errors.New("--vz-watchdog-restart requires --vz-watchdog-timeout")
+	}
+
+	d.MetricsAddress = opts.String("vz-metrics-address")
+
+	d.StopTimeout = uint(opts.Int("vz-stop-timeout"))
+
+	consoleLogSize := opts.Int("vz-console-log-size")
+	if consoleLogSize <= 0 {
+		return errors.Errorf("Invalid console log size %d, must be at least 1", consoleLogSize)
+	}
+	d.ConsoleLogSize = uint(consoleLogSize)
+	consoleLogFiles := opts.Int("vz-console-log-files")
+	if consoleLogFiles < 0 {
+		return errors.Errorf("Invalid number of console logs %d, must be at least 0", consoleLogFiles)
+	}
+	files := uint(consoleLogFiles)
+	d.ConsoleLogFiles = &files
+
 	return nil
 }
 
 // Start a host
 func (d *Driver) Start() error {
+	if drivers.MachineInState(d, state.Paused)() {
+		log.Info("Resuming paused VM...")
+		return d.Resume()
+	}
+
 	if err := d.recoverFromUncleanShutdown(); err != nil {
-		return nil
+		return errors.Wrap(err, "Unable to recover from unclean shutdown")
 	}
 
 	// Unset any saved IP address
 	d.IPAddress = ""
 
+	if d.BootMode != vz.BootModeEFI {
+		if err := d.verifyBootFiles(); err != nil {
+			return err
+		}
+	}
+
 	config, err := d.generateVmConfig()
 	if err != nil {
 		return err
 	}
 
-	configBytes, err := json.Marshal(config)
-	if err != nil {
+	if err := config.Validate(); err != nil {
 		return err
 	}
 
-	configJson := string(configBytes[:])
-	log.Debugf("Config string: '%s'", configJson)
+	configPath := d.ResolveStorePath(configFileName)
+	if err := vz.WriteConfigFile(configPath, config); err != nil {
+		return errors.Wrapf(err, "Failed to write config file: %s", configPath)
+	}
 
-	cmd := exec.Command("vz", "--pid", d.ResolveStorePath(pidFileName), configJson)
+	// The launcher reports on readyWrite, its first extra file, once the
+	// VM is running or failed to start.
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		return errors.Wrap(err, "Failed to create readiness pipe")
+	}
+	defer readyRead.Close()
+
+	cmd := exec.Command("vz",
+		"--pid", d.ResolveStorePath(pidFileName),
+		"--control", d.ResolveStorePath(control.SocketFileName),
+		"--state", d.ResolveStorePath(vz.StateFileName),
+		"--ready-fd", "3",
+		"--config", configPath,
+	)
+	if d.MetricsAddress != "" {
+		cmd.Args = append(cmd.Args, "--metrics", d.MetricsAddress)
+	}
+	cmd.ExtraFiles = []*os.File{readyWrite}
+	// A session of its own keeps the VM running when the terminal docker-machine
+	// ran in goes away or is interrupted.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
 
 	logFilePath := d.ResolveStorePath("vz.out")
 	logFile, err := os.OpenFile(logFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
@@ -339,31 +865,171 @@ func (d *Driver) Start() error {
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
 
-	if err := cmd.Start(); err != nil {
+	err = cmd.Start()
+	readyWrite.Close()
+	logFile.Close()
+	if err != nil {
 		return errors.Wrap(err, "Failed to start VM")
 	}
+	// The launcher outlives the driver, which only waits for it if it
+	// failed to start.
+	defer cmd.Process.Release()
 
-	if err := d.mountSharedDirectories(config); err != nil {
+	if err := d.waitForReadiness(cmd, readyRead, logFilePath); err != nil {
 		return err
 	}
 
-	if err := cmd.Process.Release(); err != nil {
-		return errors.Wrap(err, "Failed to release VM")
+	// Ignition sets up mount units for the shares itself.
+	if d.provisioning() != ProvisionIgnition {
+		if err := d.mountSharedDirectories(config); err != nil {
+			return err
+		}
+	}
+
+	if len(config.PortForwards) > 0 || config.Watchdog != nil {
+		if err := d.startAgent(); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// Stop a host gracefully
+// waitForReadiness waits for the launcher started by cmd to report on ready
+// whether the VM started, reaping it if it didn't.
+func (d *Driver) waitForReadiness(cmd *exec.Cmd, ready *os.File, logFilePath string) error {
+	ready.SetReadDeadline(time.Now().Add(launcherReadyTimeout))
+
+	err := vz.ReadReadiness(ready)
+	if err == nil {
+		return nil
+	}
+
+	if os.IsTimeout(errors.Cause(err)) {
+		// The launcher is stuck, don't leave it behind half started.
+		cmd.Process.Kill()
+		err = errors.Errorf("vz did not report within %s", launcherReadyTimeout)
+	}
+	cmd.Wait()
+	return errors.Wrapf(err, "Failed to start VM, see %s", logFilePath)
+}
+
+// Stop a host gracefully. The guest is first asked to shut down, then
+// powered off over SSH and finally stopped forcefully, waiting up to
+// StopTimeout seconds for each step to take effect.
 func (d *Driver) Stop() error {
-	if err := d.sendSignal(os.Interrupt); err != nil {
+	// A paused guest can't act on a stop request.
+	if drivers.MachineInState(d, state.Paused)() {
+		if err := d.Resume(); err != nil {
+			return err
+		}
+	}
+
+	if err := drivers.MustBeRunning(d); err != nil {
 		return err
 	}
-	// Maybe give it some time to gracefully shutdown..?
+
+	log.Infof("Requesting %s to stop...", d.MachineName)
+	if err := d.requestStop(); err != nil {
+		log.Warnf("Failed to request stop: %s", err)
+	} else if d.waitForStop() {
+		return nil
+	}
+
+	log.Warnf("%s did not stop within %ds, powering off over SSH...", d.MachineName, d.stopTimeout())
+	if out, err := drivers.RunSSHCommandFromDriver(d, "sudo poweroff"); err != nil {
+		// The connection is expected to drop as the guest powers off.
+		log.Debugf("poweroff: %s %s", out, err)
+	}
+	if d.waitForStop() {
+		return nil
+	}
+
+	log.Warnf("%s did not power off within %ds, forcing stop...", d.MachineName, d.stopTimeout())
+	if err := d.Kill(); err != nil {
+		return errors.Wrap(err, "Failed to force stop")
+	}
+	if !d.waitForStop() {
+		return errors.Errorf("%s did not stop", d.MachineName)
+	}
 
 	return nil
 }
 
+// requestStop asks the guest to shut down.
+func (d *Driver) requestStop() error {
+	err := d.controlClient().RequestStop()
+	if errors.Is(err, control.ErrUnavailable) {
+		log.Debugf("Unable to reach vz, sending signal instead: %s", err)
+		return d.sendSignal(os.Interrupt)
+	}
+
+	return err
+}
+
+// waitForStop waits up to stopTimeout seconds for the vz process to exit.
+func (d *Driver) waitForStop() bool {
+	return mcnutils.WaitForSpecific(func() bool {
+		return !d.isLauncherRunning()
+	}, int(d.stopTimeout()), time.Second) == nil
+}
+
+// stopTimeout returns StopTimeout, defaulting it for machines created
+// before it was configurable.
+func (d *Driver) stopTimeout() uint {
+	if d.StopTimeout == 0 {
+		return defaultStopTimeout
+	}
+	return d.StopTimeout
+}
+
+// isLauncherRunning reports whether the pid file names a running vz process.
+func (d *Driver) isLauncherRunning() bool {
+	pid := d.getPid()
+	if pid == 0 {
+		return false
+	}
+
+	proc, err := ps.FindProcess(pid)
+	return err == nil && proc != nil && proc.Executable() == "vz"
+}
+
+// consoleLogSize returns ConsoleLogSize, defaulting it for machines created
+// before console logs were kept.
+func (d *Driver) consoleLogSize() uint {
+	if d.ConsoleLogSize == 0 {
+		return defaultConsoleLogSize
+	}
+	return d.ConsoleLogSize
+}
+
+// consoleLogFiles returns ConsoleLogFiles, defaulting it for machines
+// created before console logs were kept.
+func (d *Driver) consoleLogFiles() uint {
+	if d.ConsoleLogFiles == nil {
+		return defaultConsoleLogFiles
+	}
+	return *d.ConsoleLogFiles
+}
+
+// Pause freezes a running host while keeping its memory contents.
+func (d *Driver) Pause() error {
+	if err := drivers.MustBeRunning(d); err != nil {
+		return err
+	}
+
+	return d.controlClient().Pause()
+}
+
+// Resume continues a paused host.
+func (d *Driver) Resume() error {
+	if !drivers.MachineInState(d, state.Paused)() {
+		return errors.New("Host is not paused")
+	}
+
+	return d.controlClient().Resume()
+}
+
 func (d *Driver) getPid() int {
 	pidPath := d.ResolveStorePath(pidFileName)
 
@@ -385,6 +1051,27 @@ func (d *Driver) getPid() int {
 	return pid
 }
 
+func (d *Driver) controlClient() *control.Client {
+	return control.NewClient(d.ResolveStorePath(control.SocketFileName))
+}
+
+// machineState maps a vz state name onto a libmachine state.
+func machineState(stateName string) state.State {
+	switch stateName {
+	case "Starting", "Resuming", "Restarting":
+		return state.Starting
+	case "Running":
+		return state.Running
+	case "Pausing", "Paused":
+		return state.Paused
+	case "Stopped":
+		return state.Stopped
+	case "Error":
+		return state.Error
+	}
+	return state.None
+}
+
 func (d *Driver) sendSignal(s os.Signal) error {
 	if err := drivers.MustBeRunning(d); err != nil {
 		return err
@@ -433,13 +1120,21 @@ func (d *Driver) recoverFromUncleanShutdown() error {
 		return errors.Wrapf(err, "parsing pidfile %s", pidFile)
 	}
 
+	if status, err := d.readStateFile(); err == nil && status.Exited && status.Pid == pid {
+		log.Debugf("vz pid %d exited (%s), removing stale pid file %s...", pid, status.LastExit.Reason, pidFile)
+		if err := os.Remove(pidFile); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("removing pidFile %s", pidFile))
+		}
+		return nil
+	}
+
 	st, err := d.GetState()
 	if err != nil {
 		return errors.Wrap(err, "pidState")
 	}
 
 	log.Debugf("pid %d is in state %q", pid, st)
-	if st == state.Running {
+	if st == state.Running || st == state.Paused {
 		return nil
 	}
 
@@ -452,74 +1147,81 @@ func (d *Driver) recoverFromUncleanShutdown() error {
 }
 
 func (d *Driver) extractKernel() error {
-	log.Debugf("Mounting %s", isoFileName)
+	log.Debugf("Reading %s", isoFileName)
 
-	volumeRootDir := d.ResolveStorePath(isoMountPath)
-	err := hdiutil("attach", d.ResolveStorePath(isoFileName), "-mountpoint", volumeRootDir)
+	isoFile, err := os.Open(d.ResolveStorePath(isoFileName))
 	if err != nil {
 		return err
 	}
-	defer func() error {
-		log.Debugf("Unmounting %s", isoFileName)
-		return hdiutil("detach", volumeRootDir)
-	}()
+	defer isoFile.Close()
 
-	log.Debugf("Extracting Kernel Command Line Options...")
-	if err := d.extractKernelCommandLineOptions(); err != nil {
-		return err
+	iso, err := iso9660.NewReader(isoFile)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read %s", isoFileName)
 	}
 
-	isoKernel := ""
-	isoInitrd := ""
-	if d.Kernel == "" && d.Initrd == "" {
-		filepath.Walk(volumeRootDir, func(path string, f os.FileInfo, err error) error {
-			if kernelRegexp.MatchString(path) {
-				isoKernel = path
-				_, d.Kernel = filepath.Split(isoKernel)
-			}
-			if strings.Contains(path, "initrd") {
-				isoInitrd = path
-				_, d.Initrd = filepath.Split(isoInitrd)
-			}
+	var isoKernel, isoInitrd, isolinuxConfig *iso9660.File
+	err = iso.Walk(func(f *iso9660.File) error {
+		// Symlinks and other special files have no content to extract.
+		if !f.Mode.IsRegular() {
 			return nil
-		})
+		}
+		if kernelRegexp.MatchString(f.Path) {
+			isoKernel = f
+		}
+		if strings.Contains(f.Path, "initrd") {
+			isoInitrd = f
+		}
+		if strings.Contains(f.Path, "isolinux.cfg") {
+			isolinuxConfig = f
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to list %s", isoFileName)
 	}
 
-	if d.Kernel == "" || d.Initrd == "" {
+	// Options given with --vz-cmdline replace those of the ISO.
+	if d.Cmdline == "" {
+		log.Debugf("Extracting Kernel Command Line Options...")
+		if err := d.extractKernelCommandLineOptions(isolinuxConfig); err != nil {
+			return err
+		}
+	}
+
+	// Files given with --vz-kernel and --vz-initrd are already in place.
+	if (d.Kernel == "" && isoKernel == nil) || (d.Initrd == "" && isoInitrd == nil) {
 		err := fmt.Errorf("Unable to locate Kernel and/or Initial Ramdisk file(s)")
 		return err
 	}
 
-	dest := d.ResolveStorePath(d.Kernel)
-	log.Debugf("Extracting %s into %s", isoKernel, dest)
-	if err := mcnutils.CopyFile(isoKernel, dest); err != nil {
-		return err
+	if d.Kernel == "" {
+		d.Kernel = path.Base(isoKernel.Path)
+		dest := d.ResolveStorePath(d.Kernel)
+		log.Debugf("Extracting %s into %s", isoKernel.Path, dest)
+		if err := extractFile(isoKernel, dest); err != nil {
+			return err
+		}
 	}
 
-	dest = d.ResolveStorePath(d.Initrd)
-	log.Debugf("Extracting %s into %s", isoInitrd, dest)
-	if err := mcnutils.CopyFile(isoInitrd, dest); err != nil {
-		return err
+	if d.Initrd == "" {
+		d.Initrd = path.Base(isoInitrd.Path)
+		dest := d.ResolveStorePath(d.Initrd)
+		log.Debugf("Extracting %s into %s", isoInitrd.Path, dest)
+		if err := extractFile(isoInitrd, dest); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (d *Driver) extractKernelCommandLineOptions() error {
-	volumeRootDir := d.ResolveStorePath(isoMountPath)
-
-	var cmdLine string
-	extractKernelCommandLineOptions := func(path string, f os.FileInfo, err error) error {
-		if strings.Contains(path, "isolinux.cfg") {
-			cmdLine, err = readKernelCommandLine(path)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+func (d *Driver) extractKernelCommandLineOptions(isolinuxConfig *iso9660.File) error {
+	if isolinuxConfig == nil {
+		return errors.New("Not able to find isolinux.cfg")
 	}
 
-	err := filepath.Walk(volumeRootDir, extractKernelCommandLineOptions)
+	cmdLine, err := readKernelCommandLine(isolinuxConfig.Open(), isolinuxConfig.Path)
 	if err != nil {
 		return err
 	}
@@ -535,48 +1237,127 @@ func (d *Driver) extractKernelCommandLineOptions() error {
 }
 
 func (d *Driver) generateVmConfig() (*vz.VirtualMachineConfig, error) {
-	networkInterfaces := make([]vz.VirtualMachineNetworkInterface, 1)
-	if d.getMacAddress() == nil {
-		d.setMacAddress(vz.NewRandomLocallyAdministeredHardwareAddr())
+	networkInterfaces := d.networkInterfaces()
+
+	sharedDirectories, err := d.sharedDirectories()
+	if err != nil {
+		return nil, err
 	}
-	networkInterfaces[0].MACAddress = d.MACAddress
 
-	sharedDirectories := []vz.VirtualMachineSharedDirectory{}
-	if d.ShareDirectory {
-		directory, err := os.UserHomeDir()
+	var portForwards []vz.VirtualMachinePortForward
+	for _, spec := range d.PortForwards {
+		forward, err := vz.ParsePortForward(spec)
 		if err != nil {
 			return nil, err
 		}
+		portForwards = append(portForwards, forward)
+	}
 
-		sharedDirectories = append(sharedDirectories, vz.VirtualMachineSharedDirectory{
-			Directory: directory,
-			Tag:       "Home",
+	var disks []vz.VirtualMachineDiskConfig
+	if d.usesISO() {
+		disks = append(disks, vz.VirtualMachineDiskConfig{
+			Path:     d.ResolveStorePath(isoFileName),
+			ReadOnly: true,
+		})
+	}
+	disks = append(disks, vz.VirtualMachineDiskConfig{
+		Path:     GetDiskPath(d.BaseDriver),
+		ReadOnly: false,
+	})
+	if d.provisioning() == ProvisionCloudInit {
+		disks = append(disks, vz.VirtualMachineDiskConfig{
+			Path:     d.ResolveStorePath(seedFileName),
+			ReadOnly: true,
+		})
+	}
+	if d.provisioning() == ProvisionIgnition && d.ignitionDelivery() == IgnitionDeliveryDisk {
+		disks = append(disks, vz.VirtualMachineDiskConfig{
+			Path:     d.ResolveStorePath(ignitionDiskFileName),
+			ReadOnly: true,
 		})
 	}
 
 	config := vz.VirtualMachineConfig{
-		CPUs:   d.CPU,
-		Memory: d.Memory,
-		Disks: []vz.VirtualMachineDiskConfig{
-			{
-				Path:     d.ResolveStorePath(isoFileName),
-				ReadOnly: true,
-			},
+		CPUs:              d.CPU,
+		Memory:            d.Memory,
+		Disks:             disks,
+		NetworkInterfaces: networkInterfaces,
+		SharedDirectories: sharedDirectories,
+		MemoryBalloon:     d.MemoryBalloon,
+		PortForwards:      portForwards,
+		SerialPorts: []vz.VirtualMachineSerialPort{
 			{
-				Path:     GetDiskPath(d.BaseDriver),
-				ReadOnly: false,
+				Type: vz.SerialPortUnix,
+				Path: d.ResolveStorePath(consoleSocketFileName),
+				Log: &vz.VirtualMachineSerialLog{
+					Path:     d.ResolveStorePath(consoleLogFileName),
+					MaxSize:  int64(d.consoleLogSize()) * 1024 * 1024,
+					MaxFiles: int(d.consoleLogFiles()),
+				},
 			},
 		},
-		Kernel:            d.ResolveStorePath(d.Kernel),
-		Initrd:            d.ResolveStorePath(d.Initrd),
-		CmdLine:           fmt.Sprintln(baseCmdLineOptions, d.Cmdline),
-		NetworkInterfaces: networkInterfaces,
-		SharedDirectories: sharedDirectories,
+	}
+
+	if d.RestartPolicy != "" && d.RestartPolicy != vz.RestartNo {
+		config.RestartPolicy = &vz.VirtualMachineRestartPolicy{
+			Mode:       d.RestartPolicy,
+			MaxRetries: d.RestartMaxRetries,
+		}
+	}
+
+	if d.WatchdogTimeout > 0 {
+		config.Watchdog = &vz.VirtualMachineWatchdog{
+			Timeout: d.WatchdogTimeout,
+			Restart: d.WatchdogRestart,
+		}
+	}
+
+	if d.BootMode == vz.BootModeEFI {
+		config.BootMode = vz.BootModeEFI
+		config.EFIVariableStore = d.ResolveStorePath(efiVariableStoreFileName)
+	} else {
+		config.Kernel = d.ResolveStorePath(d.Kernel)
+		config.Initrd = d.ResolveStorePath(d.Initrd)
+		cmdLine, err := d.kernelCmdLine(false)
+		if err != nil {
+			return nil, err
+		}
+		config.CmdLine = cmdLine
+		d.KernelCmdLine = cmdLine
+
+		// The launcher only boots with the options provisioning the guest
+		// once, not when the guest reboots or is restarted.
+		if d.firstBoot {
+			firstBootCmdLine, err := d.kernelCmdLine(true)
+			if err != nil {
+				return nil, err
+			}
+			if firstBootCmdLine != cmdLine {
+				config.FirstBootCmdLine = firstBootCmdLine
+			}
+		}
 	}
 
 	return &config, nil
 }
 
+// sharedDirectories returns the directories shared with the machine.
+func (d *Driver) sharedDirectories() ([]vz.VirtualMachineSharedDirectory, error) {
+	sharedDirectories := []vz.VirtualMachineSharedDirectory{}
+	if d.ShareDirectory {
+		directory, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+
+		sharedDirectories = append(sharedDirectories, vz.VirtualMachineSharedDirectory{
+			Directory: directory,
+			Tag:       "Home",
+		})
+	}
+	return sharedDirectories, nil
+}
+
 func (d *Driver) mountSharedDirectories(config *vz.VirtualMachineConfig) error {
 	if len(config.SharedDirectories) > 0 {
 		mountCommands := fmt.Sprintf("#!/bin/sh\\n")
@@ -608,10 +1389,60 @@ func (d *Driver) mountSharedDirectories(config *vz.VirtualMachineConfig) error {
 	return nil
 }
 
-func (d *Driver) getMacAddress() net.HardwareAddr {
-	return d.MACAddress.ToNetHardwareAddr()
+// checkBridgeInterface returns an error listing the interfaces that can be
+// bridged to if iface is not one of them.
+func checkBridgeInterface(iface string) error {
+	interfaces, err := vz.BridgeInterfaces()
+	if err != nil {
+		return errors.Wrap(err, "Unable to list interfaces for bridging")
+	}
+
+	var available []string
+	for _, bridgeInterface := range interfaces {
+		if bridgeInterface.Identifier == iface {
+			return nil
+		}
+		available = append(available, fmt.Sprintf("%s (%s)", bridgeInterface.Identifier, bridgeInterface.DisplayName))
+	}
+	return errors.Errorf("Unable to bridge to %s, available interfaces: %s", iface, strings.Join(available, ", "))
 }
 
-func (d *Driver) setMacAddress(a net.HardwareAddr) {
-	d.MACAddress = vznet.FromNetHardwareAddr(a)
+// networkInterfaces returns the machine's network interfaces, moving the
+// legacy single interface into NetworkInterfaces, defaulting to one NAT
+// interface and assigning MAC addresses to interfaces that have none.
+func (d *Driver) networkInterfaces() []vz.VirtualMachineNetworkInterface {
+	if len(d.NetworkInterfaces) == 0 {
+		d.NetworkInterfaces = []vz.VirtualMachineNetworkInterface{
+			{
+				BridgeInterface: d.BridgeInterface,
+				MACAddress:      d.MACAddress,
+			},
+		}
+		d.MACAddress = vznet.HardwareAddr{}
+		d.BridgeInterface = ""
+	}
+
+	for i := range d.NetworkInterfaces {
+		network := &d.NetworkInterfaces[i]
+		if network.Type == "" {
+			network.Type = network.Attachment()
+		}
+		if network.MACAddress.ToNetHardwareAddr() == nil {
+			network.MACAddress = vznet.FromNetHardwareAddr(vznet.NewRandomLocallyAdministeredHardwareAddr())
+		}
+	}
+	return d.NetworkInterfaces
+}
+
+// endpointInterface returns the network interface the machine's IP address
+// is looked up for: the first NAT or bridged interface. File handle
+// interfaces are skipped since the host can't tell the addresses on them.
+func (d *Driver) endpointInterface() (vz.VirtualMachineNetworkInterface, bool) {
+	for _, network := range d.networkInterfaces() {
+		switch network.Attachment() {
+		case vz.NetworkNAT, vz.NetworkBridged:
+			return network, true
+		}
+	}
+	return vz.VirtualMachineNetworkInterface{}, false
 }
@@ -0,0 +1,351 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/iso9660"
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz/vztest"
+)
+
+func newTestDriver(t *testing.T) *Driver {
+	d := NewDriver("test", t.TempDir()).(*Driver)
+	d.Kernel = "bzImage"
+	d.Initrd = "initrd.img"
+	d.Cmdline = "loglevel=3"
+
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{d.Kernel, d.Initrd, isoFileName, GetDiskPath(d.BaseDriver)} {
+		if err := os.WriteFile(d.ResolveStorePath(filepath.Base(file)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return d
+}
+
+func TestDriver_generateVmConfig(t *testing.T) {
+	d := newTestDriver(t)
+
+	config, err := d.generateVmConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error generating config: %s", err)
+	}
+
+	backend := vztest.NewBackend()
+	vm, err := vz.NewVirtualMachine(backend, config)
+	if err != nil {
+		t.Fatalf("Unexpected error creating machine: %s", err)
+	}
+	defer vm.Close()
+	spec := backend.Machines()[0].Spec
+
+	if spec.BootLoader.Kernel != d.ResolveStorePath("bzImage") || spec.BootLoader.Initrd != d.ResolveStorePath("initrd.img") {
+		t.Errorf("Unexpected boot loader %+v", spec.BootLoader)
+	}
+	if spec.CPUs != defaultCPU || spec.MemorySize != defaultMemory*1024*1024 {
+		t.Errorf("Unexpected CPUs %d and memory %d", spec.CPUs, spec.MemorySize)
+	}
+
+	wantDisks := []vz.StorageDevice{
+		{Path: d.ResolveStorePath(isoFileName), ReadOnly: true},
+		{Path: filepath.Join(d.StorePath, "machines", "test", "test.rawdisk")},
+	}
+	if len(spec.StorageDevices) != len(wantDisks) {
+		t.Fatalf("Wanted %d storage devices but got %d", len(wantDisks), len(spec.StorageDevices))
+	}
+	for i, want := range wantDisks {
+		if spec.StorageDevices[i] != want {
+			t.Errorf("Wanted storage device %+v but got %+v", want, spec.StorageDevices[i])
+		}
+	}
+
+	if len(spec.NetworkDevices) != 1 {
+		t.Fatalf("Wanted 1 network device but got %d", len(spec.NetworkDevices))
+	}
+	if mac := d.NetworkInterfaces[0].MACAddress.ToNetHardwareAddr(); !bytes.Equal(spec.NetworkDevices[0].MACAddress, mac) {
+		t.Errorf("Network device MAC %s doesn't match the machine's %s", spec.NetworkDevices[0].MACAddress, mac)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.DirectoryShares) != 1 || spec.DirectoryShares[0].Directory != home {
+		t.Errorf("Unexpected directory shares %+v", spec.DirectoryShares)
+	}
+
+	if !strings.HasSuffix(spec.BootLoader.CmdLine, " "+consoleCmdLineOption) {
+		t.Errorf("Wanted command line %q to end with %s", spec.BootLoader.CmdLine, consoleCmdLineOption)
+	}
+	if d.KernelCmdLine != spec.BootLoader.CmdLine {
+		t.Errorf("Wanted the machine to record command line %q but got %q", spec.BootLoader.CmdLine, d.KernelCmdLine)
+	}
+	if len(spec.SerialPorts) != 1 || spec.SerialPorts[0].Read == nil {
+		t.Errorf("Unexpected serial ports %+v", spec.SerialPorts)
+	}
+	if _, err := os.Stat(d.ResolveStorePath(consoleLogFileName)); err != nil {
+		t.Errorf("Wanted console log to be created: %s", err)
+	}
+}
+
+func TestDriver_generateVmConfigKeepsMACAddress(t *testing.T) {
+	d := newTestDriver(t)
+
+	first, err := d.generateVmConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error generating config: %s", err)
+	}
+	second, err := d.generateVmConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error generating config: %s", err)
+	}
+
+	if first.NetworkInterfaces[0].MACAddress.String() != second.NetworkInterfaces[0].MACAddress.String() {
+		t.Errorf("MAC address changed from %s to %s", first.NetworkInterfaces[0].MACAddress, second.NetworkInterfaces[0].MACAddress)
+	}
+}
+
+func TestDriver_generateVmConfigDefaultsConsoleLog(t *testing.T) {
+	d := newTestDriver(t)
+	// Machines created before console logs were kept have neither set.
+	d.ConsoleLogSize, d.ConsoleLogFiles = 0, nil
+
+	config, err := d.generateVmConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error generating config: %s", err)
+	}
+
+	log := config.SerialPorts[0].Log
+	if log == nil || log.MaxSize != defaultConsoleLogSize*1024*1024 || log.MaxFiles != defaultConsoleLogFiles {
+		t.Errorf("Wanted the default console log rotation but got %+v", log)
+	}
+}
+
+// fakeFlags are the values of the flags of docker-machine create, by name.
+type fakeFlags map[string]interface{}
+
+func (f fakeFlags) String(key string) string {
+	s, _ := f[key].(string)
+	return s
+}
+
+func (f fakeFlags) StringSlice(key string) []string {
+	s, _ := f[key].([]string)
+	return s
+}
+
+func (f fakeFlags) Int(key string) int {
+	i, _ := f[key].(int)
+	return i
+}
+
+func (f fakeFlags) Bool(key string) bool {
+	b, _ := f[key].(bool)
+	return b
+}
+
+func TestDriver_SetConfigFromFlags(t *testing.T) {
+	tests := []struct {
+		msg          string
+		flags        map[string]interface{}
+		wantLogSize  uint
+		wantLogFiles uint
+		wantErr      bool
+	}{
+		{
+			msg:          "defaults",
+			wantLogSize:  defaultConsoleLogSize,
+			wantLogFiles: defaultConsoleLogFiles,
+		}, {
+			msg:          "no previous console logs",
+			flags:        map[string]interface{}{"vz-console-log-files": 0},
+			wantLogSize:  defaultConsoleLogSize,
+			wantLogFiles: 0,
+		}, {
+			msg:     "negative number of console logs",
+			flags:   map[string]interface{}{"vz-console-log-files": -1},
+			wantErr: true,
+		}, {
+			msg:     "zero console log size",
+			flags:   map[string]interface{}{"vz-console-log-size": 0},
+			wantErr: true,
+		}, {
+			msg:     "negative console log size",
+			flags:   map[string]interface{}{"vz-console-log-size": -1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			d := newTestDriver(t)
+			opts := fakeFlags{}
+			for _, flag := range d.GetCreateFlags() {
+				opts[flag.String()] = flag.Default()
+			}
+			for name, value := range tt.flags {
+				opts[name] = value
+			}
+
+			err := d.SetConfigFromFlags(opts)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Wanted an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if d.consoleLogSize() != tt.wantLogSize || d.consoleLogFiles() != tt.wantLogFiles {
+				t.Errorf("Wanted console logs of %d MiB keeping %d but got %d MiB keeping %d", tt.wantLogSize, tt.wantLogFiles, d.consoleLogSize(), d.consoleLogFiles())
+			}
+		})
+	}
+}
+
+func TestDriver_networkInterfacesMigratesMACAddress(t *testing.T) {
+	tests := []struct {
+		msg             string
+		bridgeInterface string
+		wantType        vz.NetworkAttachment
+	}{
+		{
+			msg:      "NAT",
+			wantType: vz.NetworkNAT,
+		}, {
+			msg:             "bridged",
+			bridgeInterface: "en0",
+			wantType:        vz.NetworkBridged,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			d := newTestDriver(t)
+			if err := json.Unmarshal([]byte(`{"MACAddress": "02:00:00:00:00:01", "BridgeInterface": "`+tt.bridgeInterface+`"}`), d); err != nil {
+				t.Fatal(err)
+			}
+
+			networks := d.networkInterfaces()
+			if len(networks) != 1 {
+				t.Fatalf("Wanted 1 network interface but got %d", len(networks))
+			}
+			if networks[0].Type != tt.wantType || networks[0].BridgeInterface != tt.bridgeInterface {
+				t.Errorf("Wanted a %s interface bridged to %q but got %+v", tt.wantType, tt.bridgeInterface, networks[0])
+			}
+			if mac := networks[0].MACAddress.String(); mac != "02:00:00:00:00:01" {
+				t.Errorf("Wanted MAC address 02:00:00:00:00:01 to be kept but got %s", mac)
+			}
+
+			network, ok := d.endpointInterface()
+			if !ok || network.MACAddress.String() != "02:00:00:00:00:01" {
+				t.Errorf("Unexpected endpoint interface %+v", network)
+			}
+		})
+	}
+}
+
+func TestDriver_endpointInterfaceSkipsFileHandles(t *testing.T) {
+	d := newTestDriver(t)
+	d.NetworkInterfaces = []vz.VirtualMachineNetworkInterface{
+		{Type: vz.NetworkFileHandle, Socket: "/tmp/switch.sock"},
+		{Type: vz.NetworkNAT},
+		{Type: vz.NetworkBridged, BridgeInterface: "en0"},
+	}
+
+	network, ok := d.endpointInterface()
+	if !ok || network.Type != vz.NetworkNAT {
+		t.Errorf("Wanted the NAT interface but got %+v", network)
+	}
+	for i, network := range d.NetworkInterfaces {
+		if network.MACAddress.ToNetHardwareAddr() == nil {
+			t.Errorf("Wanted networkInterfaces[%d] to be assigned a MAC address", i)
+		}
+	}
+}
+
+func TestDriver_generateVmConfigEFI(t *testing.T) {
+	d := newTestDriver(t)
+	d.BootMode = vz.BootModeEFI
+
+	config, err := d.generateVmConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error generating config: %s", err)
+	}
+
+	backend := vztest.NewBackend()
+	vm, err := vz.NewVirtualMachine(backend, config)
+	if err != nil {
+		t.Fatalf("Unexpected error creating machine: %s", err)
+	}
+	defer vm.Close()
+	spec := backend.Machines()[0].Spec
+
+	if spec.EFIBootLoader == nil || spec.EFIBootLoader.VariableStore != d.ResolveStorePath(efiVariableStoreFileName) {
+		t.Errorf("Unexpected EFI boot loader %+v", spec.EFIBootLoader)
+	}
+	if spec.BootLoader.Kernel != "" {
+		t.Errorf("Wanted no kernel to be booted but got %+v", spec.BootLoader)
+	}
+}
+
+func TestDriver_extractKernel(t *testing.T) {
+	d := newTestDriver(t)
+	d.Kernel, d.Initrd, d.Cmdline = "", "", ""
+
+	iso := iso9660.NewWriter("b2d-v20.10.17")
+	for name, data := range map[string]string{
+		"boot/vmlinuz64":             "kernel",
+		"boot/initrd.img":            "initrd",
+		"boot/isolinux/isolinux.cfg": "label boot2docker\n\tkernel /boot/vmlinuz64\n\tappend loglevel=3 user=docker\n",
+		"boot/vmlinuz64-link":        "link",
+	} {
+		if err := iso.AddFile(name, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var image bytes.Buffer
+	if _, err := iso.WriteTo(&image); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make /boot/vmlinuz64-link a symlink, which matches as a kernel too,
+	// by the mode of its Rock Ridge PX entry preceding its NM entry.
+	data := image.Bytes()
+	name := bytes.Index(data, []byte("NM\x13\x01\x00vmlinuz64-link"))
+	if name < 0 {
+		t.Fatal("No Rock Ridge name of /boot/vmlinuz64-link")
+	}
+	px := bytes.LastIndex(data[:name], []byte("PX\x24\x01"))
+	if px < 0 {
+		t.Fatal("No Rock Ridge attributes of /boot/vmlinuz64-link")
+	}
+	binary.LittleEndian.PutUint32(data[px+4:], 0o120777)
+	binary.BigEndian.PutUint32(data[px+8:], 0o120777)
+	if err := os.WriteFile(d.ResolveStorePath(isoFileName), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.extractKernel(); err != nil {
+		t.Fatalf("Unexpected error extracting kernel: %s", err)
+	}
+	if d.Kernel != "vmlinuz64" || d.Initrd != "initrd.img" {
+		t.Errorf("Unexpected kernel %q and initrd %q", d.Kernel, d.Initrd)
+	}
+	if d.Cmdline != "loglevel=3 user=docker" {
+		t.Errorf("Unexpected command line options %q", d.Cmdline)
+	}
+	for file, want := range map[string]string{d.Kernel: "kernel", d.Initrd: "initrd"} {
+		if got, err := os.ReadFile(d.ResolveStorePath(file)); err != nil || string(got) != want {
+			t.Errorf("Wanted %s to be extracted but got %q (%v)", file, got, err)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package driver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/iso9660"
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/pkg/errors"
+)
+
+// IgnitionDelivery is how the Ignition config reaches the guest.
+type IgnitionDelivery string
+
+const (
+	// IgnitionDeliveryDisk attaches an OpenStack config drive holding the
+	// config, which Ignition reads on the openstack platform.
+	IgnitionDeliveryDisk IgnitionDelivery = "disk"
+	// IgnitionDeliveryCmdline passes the config as a data URL in
+	// ignition.config.url, which Ignition reads on the metal platform.
+	IgnitionDeliveryCmdline IgnitionDelivery = "cmdline"
+)
+
+const (
+	ignitionVersion = "3.0.0"
+
+	// defaultIgnitionSSHUser is the user of Fedora CoreOS and Flatcar,
+	// which the machine's key is added to.
+	defaultIgnitionSSHUser = "core"
+
+	// ignitionFileName is the config rendered for the machine, and
+	// ignitionDiskFileName the config drive holding it.
+	ignitionFileName     = "ignition.json"
+	ignitionDiskFileName = "config-2.iso"
+
+	// The config drive is found by its label, with the config as the
+	// user data of the OpenStack metadata.
+	configDriveLabel    = "config-2"
+	configDriveUserData = "openstack/latest/user_data"
+)
+
+// The subset of the Ignition v3 config spec the driver renders.
+type ignitionConfig struct {
+	Ignition ignitionMetadata `json:"ignition"`
+	Passwd   ignitionPasswd   `json:"passwd"`
+	Storage  ignitionStorage  `json:"storage"`
+	Systemd  ignitionSystemd  `json:"systemd"`
+}
+
+type ignitionMetadata struct {
+	Version string                    `json:"version"`
+	Config  *ignitionConfigReferences `json:"config,omitempty"`
+}
+
+type ignitionConfigReferences struct {
+	Merge []ignitionResource `json:"merge,omitempty"`
+}
+
+type ignitionResource struct {
+	Source string `json:"source"`
+}
+
+type ignitionPasswd struct {
+	Users []ignitionUser `json:"users,omitempty"`
+}
+
+type ignitionUser struct {
+	Name              string   `json:"name"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
+}
+
+type ignitionStorage struct {
+	Files []ignitionFile `json:"files,omitempty"`
+}
+
+type ignitionFile struct {
+	Path      string           `json:"path"`
+	Mode      int              `json:"mode"`
+	Overwrite bool             `json:"overwrite"`
+	Contents  ignitionResource `json:"contents"`
+}
+
+type ignitionSystemd struct {
+	Units []ignitionUnit `json:"units,omitempty"`
+}
+
+type ignitionUnit struct {
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Contents string `json:"contents"`
+}
+
+// ignitionDelivery returns how the Ignition config reaches the guest, which
+// is a config drive unless --vz-ignition-delivery says otherwise.
+func (d *Driver) ignitionDelivery() IgnitionDelivery {
+	if d.IgnitionDelivery == "" {
+		return IgnitionDeliveryDisk
+	}
+	return d.IgnitionDelivery
+}
+
+// checkIgnition checks the flags of machines provisioned by Ignition.
+func (d *Driver) checkIgnition() error {
+	switch d.ignitionDelivery() {
+	case IgnitionDeliveryDisk:
+	case IgnitionDeliveryCmdline:
+		// The kernel command line is baked into the image in EFI boot mode.
+		if d.BootMode == vz.BootModeEFI {
+			return errors.Errorf("--vz-ignition-delivery %s is not supported in %s boot mode", IgnitionDeliveryCmdline, vz.BootModeEFI)
+		}
+	default:
+		return errors.Errorf("Invalid Ignition delivery %q, must be %s or %s", d.IgnitionDelivery, IgnitionDeliveryDisk, IgnitionDeliveryCmdline)
+	}
+	return nil
+}
+
+// readIgnitionConfig reads the Ignition config given with
+// --vz-ignition-config, checking that it is a v3 config.
+func readIgnitionConfig(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read Ignition config")
+	}
+
+	var config struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse %s", path)
+	}
+	if !strings.HasPrefix(config.Ignition.Version, "3.") {
+		return nil, errors.Errorf("%s has Ignition version %q, must be 3.x", path, config.Ignition.Version)
+	}
+	return data, nil
+}
+
+// dataURL returns a data URL with data.
+func dataURL(data []byte) string {
+	return "data:;base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// systemdMountUnit returns the name of the mount unit of path, escaped as
+// by systemd-escape --path.
+func systemdMountUnit(path string) string {
+	path = strings.Trim(filepath.Clean(path), "/")
+	if path == "" {
+		return "-.mount"
+	}
+
+	var name strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '/':
+			name.WriteByte('-')
+		case c == '.' && i == 0,
+			!(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == ':' || c == '_' || c == '.'):
+			fmt.Fprintf(&name, `\x%02x`, c)
+		default:
+			name.WriteByte(c)
+		}
+	}
+	return name.String() + ".mount"
+}
+
+// mkdirMountPointScript creates the directory $0. The root of Fedora CoreOS
+// is immutable, so it is made mutable for as long as that takes, as podman
+// machine does, and left as it was on other systems. The dollar signs are
+// doubled for systemd, which would expand them itself.
+const mkdirMountPointScript = `if lsattr -d / | grep -q "^[^ ]*i"; then ` +
+	`chattr -i / && mkdir -p "$$0"; status=$$?; chattr +i /; exit $$status; fi; ` +
+	`mkdir -p "$$0"`
+
+// mountPointUnit returns the name of the service creating the mount point of
+// the mount unit mountUnit.
+func mountPointUnit(mountUnit string) string {
+	return "mkdir-" + strings.TrimSuffix(mountUnit, ".mount") + ".service"
+}
+
+// ignitionConfig renders the Ignition config of the machine, which adds
+// publicKey to the SSH user, sets the hostname and mounts the shared
+// directories, merged with the user's --vz-ignition-config.
+func (d *Driver) ignitionConfig(publicKey string) ([]byte, error) {
+	config := ignitionConfig{
+		Ignition: ignitionMetadata{Version: ignitionVersion},
+		Passwd: ignitionPasswd{
+			Users: []ignitionUser{
+				{Name: d.GetSSHUsername(), SSHAuthorizedKeys: []string{strings.TrimSpace(publicKey)}},
+			},
+		},
+		Storage: ignitionStorage{
+			Files: []ignitionFile{
+				{
+					Path:      "/etc/hostname",
+					Mode:      0o644,
+					Overwrite: true,
+					Contents:  ignitionResource{Source: dataURL([]byte(d.MachineName + "\n"))},
+				},
+			},
+		},
+	}
+
+	sharedDirectories, err := d.sharedDirectories()
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range sharedDirectories {
+		mountUnit := systemdMountUnit(share.Directory)
+		mkdirUnit := mountPointUnit(mountUnit)
+		config.Systemd.Units = append(config.Systemd.Units, ignitionUnit{
+			Name: mkdirUnit,
+			Contents: fmt.Sprintf("[Unit]\nDescription=Create the mount point of the %s share\n"+
+				"DefaultDependencies=no\nConditionPathExists=!%s\nBefore=%s\n\n"+
+				"[Service]\nType=oneshot\nExecStart=/bin/sh -c %q %q\n",
+				share.Tag, share.Directory, mountUnit, mkdirMountPointScript, share.Directory),
+		}, ignitionUnit{
+			Name:    mountUnit,
+			Enabled: true,
+			Contents: fmt.Sprintf("[Unit]\nDescription=Mount the %s share\nRequires=%s\nAfter=%s\n\n"+
+				"[Mount]\nWhat=%s\nWhere=%s\nType=virtiofs\n\n"+
+				"[Install]\nWantedBy=local-fs.target\n", share.Tag, mkdirUnit, mkdirUnit, share.Tag, share.Directory),
+		})
+	}
+
+	// Ignition merges the user's config into the driver's itself, with the
+	// semantics of its spec.
+	if d.IgnitionConfig != "" {
+		userConfig, err := readIgnitionConfig(d.IgnitionConfig)
+		if err != nil {
+			return nil, err
+		}
+		config.Ignition.Config = &ignitionConfigReferences{
+			Merge: []ignitionResource{{Source: dataURL(userConfig)}},
+		}
+	}
+
+	return json.Marshal(config)
+}
+
+// ignitionKernelArgs returns the kernel command line options Ignition
+// reads its platform and, on first boot, its config from.
+func (d *Driver) ignitionKernelArgs(firstBoot bool) (string, error) {
+	if d.ignitionDelivery() == IgnitionDeliveryDisk {
+		if !firstBoot {
+			return "ignition.platform.id=openstack", nil
+		}
+		return "ignition.platform.id=openstack ignition.firstboot", nil
+	}
+
+	if !firstBoot {
+		return "ignition.platform.id=metal", nil
+	}
+	config, err := os.ReadFile(d.ResolveStorePath(ignitionFileName))
+	if err != nil {
+		return "", err
+	}
+	return "ignition.platform.id=metal ignition.firstboot ignition.config.url=" + dataURL(config), nil
+}
+
+// createIgnitionConfig writes the Ignition config of the machine, and the
+// config drive holding it unless it is passed on the kernel command line.
+func (d *Driver) createIgnitionConfig() error {
+	publicKey, err := os.ReadFile(publicSSHKeyPath(d.BaseDriver))
+	if err != nil {
+		return err
+	}
+	config, err := d.ignitionConfig(string(publicKey))
+	if err != nil {
+		return err
+	}
+
+	configPath := d.ResolveStorePath(ignitionFileName)
+	log.Debugf("Writing Ignition config %s", configPath)
+	if err := os.WriteFile(configPath, config, 0o600); err != nil {
+		return errors.Wrapf(err, "Failed to write %s", configPath)
+	}
+
+	if d.ignitionDelivery() == IgnitionDeliveryCmdline {
+		if _, err := d.kernelCmdLine(true); err != nil {
+			return errors.Wrapf(err, "Unable to pass the Ignition config on the kernel command line, use --vz-ignition-delivery %s", IgnitionDeliveryDisk)
+		}
+		return nil
+	}
+
+	drive := iso9660.NewWriter(configDriveLabel)
+	if err := drive.AddFile(configDriveUserData, config); err != nil {
+		return err
+	}
+
+	drivePath := d.ResolveStorePath(ignitionDiskFileName)
+	log.Debugf("Writing Ignition config drive %s", drivePath)
+	f, err := os.OpenFile(drivePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := drive.WriteTo(f); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Failed to write %s", drivePath)
+	}
+	return f.Close()
+}
@@ -0,0 +1,116 @@
+package driver
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSystemdMountUnit(t *testing.T) {
+	tests := []struct {
+		msg  string
+		path string
+		want string
+	}{
+		{
+			msg:  "home directory",
+			path: "/Users/jane",
+			want: "Users-jane.mount",
+		}, {
+			msg:  "special characters",
+			path: "/Users/jane doe/.config/my-app/",
+			want: `Users-jane\x20doe-.config-my\x2dapp.mount`,
+		}, {
+			msg:  "root",
+			path: "/",
+			want: "-.mount",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			if got := systemdMountUnit(tt.path); got != tt.want {
+				t.Errorf("Wanted %q but got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDriver_createIgnitionConfig(t *testing.T) {
+	d := newTestDriver(t)
+	d.Provisioning = ProvisionIgnition
+	d.IgnitionConfig = filepath.Join(t.TempDir(), "config.ign")
+	if err := os.WriteFile(d.IgnitionConfig, []byte(`{"ignition": {"version": "3.3.0"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(publicSSHKeyPath(d.BaseDriver), []byte("ssh-ed25519 AAAA test\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("HOME", "/Users/jane")
+	d.ShareDirectory = true
+
+	d.firstBoot = true
+	if err := d.createIgnitionConfig(); err != nil {
+		t.Fatalf("Unexpected error creating Ignition config: %s", err)
+	}
+
+	data, err := os.ReadFile(d.ResolveStorePath(ignitionFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config ignitionConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if users := config.Passwd.Users; len(users) != 1 || users[0].Name != defaultIgnitionSSHUser || users[0].SSHAuthorizedKeys[0] != "ssh-ed25519 AAAA test" {
+		t.Errorf("Wanted the key added to %s but got %+v", defaultIgnitionSSHUser, users)
+	}
+	if files := config.Storage.Files; len(files) != 1 || files[0].Contents.Source != dataURL([]byte("test\n")) {
+		t.Errorf("Wanted /etc/hostname set to test but got %+v", files)
+	}
+	if units := config.Systemd.Units; len(units) != 2 || units[0].Name != "mkdir-Users-jane.service" || units[1].Name != "Users-jane.mount" {
+		t.Errorf("Wanted the home directory mounted but got %+v", units)
+	} else {
+		// The root of Fedora CoreOS is immutable, so the mount point has to
+		// be created before the share is mounted on it.
+		if !strings.Contains(units[0].Contents, "chattr -i / && mkdir -p") || !strings.Contains(units[0].Contents, `"/Users/jane"`) {
+			t.Errorf("Wanted the mount point created on an immutable root but got %q", units[0].Contents)
+		}
+		if !strings.Contains(units[1].Contents, "Requires=mkdir-Users-jane.service\nAfter=mkdir-Users-jane.service\n") {
+			t.Errorf("Wanted the share mounted after its mount point is created but got %q", units[1].Contents)
+		}
+	}
+	if config.Ignition.Config == nil || len(config.Ignition.Config.Merge) != 1 {
+		t.Errorf("Wanted the user's config merged but got %+v", config.Ignition)
+	}
+
+	vmConfig, err := d.generateVmConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error generating config: %s", err)
+	}
+	drive := vmConfig.Disks[len(vmConfig.Disks)-1]
+	if drive.Path != d.ResolveStorePath(ignitionDiskFileName) || !drive.ReadOnly {
+		t.Errorf("Wanted the config drive attached read-only last but got %+v", vmConfig.Disks)
+	}
+	if !strings.Contains(vmConfig.FirstBootCmdLine, "ignition.platform.id=openstack ignition.firstboot") {
+		t.Errorf("Wanted Ignition to run on the openstack platform on first boot but got %q", vmConfig.FirstBootCmdLine)
+	}
+	if !strings.Contains(vmConfig.CmdLine, "ignition.platform.id=openstack") || strings.Contains(vmConfig.CmdLine, "ignition.firstboot") {
+		t.Errorf("Wanted Ignition to only run on first boot but got %q", vmConfig.CmdLine)
+	}
+
+	d.IgnitionDelivery = IgnitionDeliveryCmdline
+	cmdLine, err := d.kernelCmdLine(true)
+	if err != nil {
+		t.Fatalf("Unexpected error rendering the kernel command line: %s", err)
+	}
+	if !strings.Contains(cmdLine, "ignition.config.url="+dataURL(data)) {
+		t.Errorf("Wanted the config on the kernel command line but got %q", cmdLine)
+	}
+
+	if cmdLine, err = d.kernelCmdLine(false); err != nil || strings.Contains(cmdLine, "ignition.firstboot") {
+		t.Errorf("Wanted Ignition to only run on first boot but got %q (%v)", cmdLine, err)
+	}
+}
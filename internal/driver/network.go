@@ -18,9 +18,12 @@ package driver
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"os/exec"
 	"regexp"
 	"strings"
 )
@@ -103,6 +106,101 @@ func parseDHCPdLeasesFile(file io.Reader) ([]DHCPEntry, error) {
 	return dhcpEntries, scanner.Err()
 }
 
+// maxProbedHosts bounds the size of the subnet probeSubnet sweeps.
+const maxProbedHosts = 1024
+
+// arpEntryRegexp matches the entries printed by `arp -an`, e.g.
+// "? (192.168.1.23) at 2:0:0:0:0:1 on en0 ifscope [ethernet]".
+var arpEntryRegexp = regexp.MustCompile(`^\S+ \(([0-9.]+)\) at ([0-9A-Fa-f:]+) on (\S+)`)
+
+type ARPEntry struct {
+	IPAddress string
+	HWAddress string
+	Interface string
+}
+
+// GetIPAddressByMACAddressFromARP looks mac up in the ARP table of iface.
+// Unlike the DHCP leases file, which only covers the NAT network, it finds
+// machines bridged to a network the host doesn't serve addresses on.
+func GetIPAddressByMACAddressFromARP(mac, iface string) (string, error) {
+	output, err := exec.Command("arp", "-an", "-i", iface).Output()
+	if err != nil {
+		return "", fmt.Errorf("Unable to read the ARP table: %s", err)
+	}
+
+	trimmedMAC := trimMacAddress(mac)
+	for _, arpEntry := range parseARPTable(bytes.NewReader(output)) {
+		if arpEntry.Interface == iface && arpEntry.HWAddress == trimmedMAC {
+			return arpEntry.IPAddress, nil
+		}
+	}
+
+	// The host only learns about the guest once they exchange packets.
+	probeSubnet(iface)
+	return "", fmt.Errorf("Could not find an IP address for %s on %s", mac, iface)
+}
+
+func parseARPTable(r io.Reader) []ARPEntry {
+	var arpEntries []ARPEntry
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		match := arpEntryRegexp.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			// e.g. "(incomplete)" entries
+			continue
+		}
+		arpEntries = append(arpEntries, ARPEntry{
+			IPAddress: match[1],
+			HWAddress: strings.ToLower(match[2]),
+			Interface: match[3],
+		})
+	}
+	return arpEntries
+}
+
+// probeSubnet sends a datagram to every address of the IPv4 subnet of iface,
+// making the host resolve, and cache, their hardware addresses.
+func probeSubnet(iface string) {
+	netInterface, err := net.InterfaceByName(iface)
+	if err != nil {
+		return
+	}
+	addrs, err := netInterface.Addrs()
+	if err != nil {
+		return
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil {
+			continue
+		}
+		ones, bits := ipNet.Mask.Size()
+		if 1<<(bits-ones) > maxProbedHosts {
+			continue
+		}
+
+		network := ipNet.IP.Mask(ipNet.Mask).To4()
+		for i := 1; i < 1<<(bits-ones)-1; i++ {
+			ip := make(net.IP, len(network))
+			copy(ip, network)
+			for b, n := len(ip)-1, i; b >= 0 && n > 0; b, n = b-1, n>>8 {
+				ip[b] |= byte(n)
+			}
+			if ip.Equal(ipNet.IP) {
+				continue
+			}
+
+			// The discard port; only the ARP request matters.
+			if conn, err := net.Dial("udp4", net.JoinHostPort(ip.String(), "9")); err == nil {
+				conn.Write([]byte{0})
+				conn.Close()
+			}
+		}
+	}
+}
+
 // trimMacAddress trimming "0" of the ten's digit
 func trimMacAddress(rawUUID string) string {
 	re := regexp.MustCompile(`0([A-Fa-f0-9](:|$))`)
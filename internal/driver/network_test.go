@@ -0,0 +1,29 @@
+package driver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseARPTable(t *testing.T) {
+	table := `? (192.168.1.1) at 0:11:22:33:44:55 on en0 ifscope [ethernet]
+? (192.168.1.23) at 2:0:0:ab:c:1 on en0 ifscope [ethernet]
+? (192.168.1.42) at (incomplete) on en0 ifscope [ethernet]
+? (192.168.64.2) at 2:0:0:ab:c:1 on bridge100 ifscope [bridge]
+`
+
+	want := []ARPEntry{
+		{IPAddress: "192.168.1.1", HWAddress: "0:11:22:33:44:55", Interface: "en0"},
+		{IPAddress: "192.168.1.23", HWAddress: "2:0:0:ab:c:1", Interface: "en0"},
+		{IPAddress: "192.168.64.2", HWAddress: "2:0:0:ab:c:1", Interface: "bridge100"},
+	}
+	got := parseARPTable(strings.NewReader(table))
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Wanted entries %+v but got %+v", want, got)
+	}
+
+	if mac := trimMacAddress("02:00:00:ab:0c:01"); mac != got[1].HWAddress {
+		t.Errorf("Wanted trimmed MAC %s to match the ARP table but got %s", got[1].HWAddress, mac)
+	}
+}
@@ -0,0 +1,130 @@
+package driver
+
+import (
+	"os"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/pkg/errors"
+)
+
+// Provisioning is how a machine's guest is set up on first boot.
+type Provisioning string
+
+const (
+	// ProvisionBoot2Docker boots the boot2docker ISO, which picks the SSH
+	// key up from the tar archive at the start of the disk.
+	ProvisionBoot2Docker Provisioning = "boot2docker"
+	// ProvisionCloudInit boots a cloud image, which cloud-init sets up from
+	// a NoCloud seed image attached to the machine.
+	ProvisionCloudInit Provisioning = "cloud-init"
+	// ProvisionIgnition boots an image such as Fedora CoreOS or Flatcar,
+	// which Ignition sets up on first boot from a config passed on the
+	// kernel command line or a config drive.
+	ProvisionIgnition Provisioning = "ignition"
+)
+
+// provisioning returns how the machine is provisioned, which is boot2docker
+// for machines created before --vz-provisioning.
+func (d *Driver) provisioning() Provisioning {
+	if d.Provisioning == "" {
+		return ProvisionBoot2Docker
+	}
+	return d.Provisioning
+}
+
+// checkProvisioning checks that the flags given are supported by the
+// machine's provisioning.
+func (d *Driver) checkProvisioning() error {
+	switch d.provisioning() {
+	case ProvisionBoot2Docker, ProvisionCloudInit, ProvisionIgnition:
+	default:
+		return errors.Errorf("Invalid provisioning %q, must be %s, %s or %s", d.Provisioning, ProvisionBoot2Docker, ProvisionCloudInit, ProvisionIgnition)
+	}
+
+	for _, flag := range []struct {
+		name      string
+		set       bool
+		supported []Provisioning
+	}{
+		{"--vz-disk-image", d.DiskImage != "", []Provisioning{ProvisionCloudInit, ProvisionIgnition}},
+		{"--vz-cloud-config", d.CloudConfig != "", []Provisioning{ProvisionCloudInit}},
+		{"--vz-ignition-config", d.IgnitionConfig != "", []Provisioning{ProvisionIgnition}},
+		{"--vz-ignition-delivery", d.IgnitionDelivery != "", []Provisioning{ProvisionIgnition}},
+	} {
+		if flag.set && !provisioningIn(d.provisioning(), flag.supported) {
+			return errors.Errorf("%s is not supported with %s provisioning", flag.name, d.provisioning())
+		}
+	}
+
+	if d.provisioning() == ProvisionIgnition {
+		if err := d.checkIgnition(); err != nil {
+			return err
+		}
+	}
+
+	if !d.usesISO() && d.BootMode != vz.BootModeEFI && (d.KernelSource == "" || d.InitrdSource == "") {
+		return errors.Errorf("--vz-kernel and --vz-initrd are required to boot %s machines in linux boot mode without --vz-boot2docker-url", d.provisioning())
+	}
+	return nil
+}
+
+func provisioningIn(p Provisioning, supported []Provisioning) bool {
+	for _, s := range supported {
+		if p == s {
+			return true
+		}
+	}
+	return false
+}
+
+// createDisk creates the disk of machines not provisioned by boot2docker,
+// from the raw image given with --vz-disk-image if any, grown to the disk
+// size.
+func (d *Driver) createDisk() error {
+	diskPath := GetDiskPath(d.BaseDriver)
+	if d.DiskImage != "" {
+		if _, err := fetchFile(d.DiskImage, diskPath); err != nil {
+			return errors.Wrap(err, "Failed to copy disk image")
+		}
+	} else {
+		f, err := os.OpenFile(diskPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+
+	info, err := os.Stat(diskPath)
+	if err != nil {
+		return err
+	}
+	size := int64(d.DiskSize) * 1000000
+	if info.Size() > size {
+		log.Warnf("Disk image of %d MB is larger than --vz-disk-size, keeping its size", info.Size()/1000000)
+		return nil
+	}
+	return os.Truncate(diskPath, size)
+}
+
+// mergeConfig merges src into dst, both decoded from YAML or JSON:
+// mappings are merged key by key, lists appended to and anything else
+// replaced by src.
+func mergeConfig(dst, src map[string]interface{}) {
+	for key, value := range src {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			if existing, ok := dst[key].(map[string]interface{}); ok {
+				mergeConfig(existing, value)
+				continue
+			}
+		case []interface{}:
+			if existing, ok := dst[key].([]interface{}); ok {
+				dst[key] = append(existing, value...)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
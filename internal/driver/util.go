@@ -19,12 +19,12 @@ package driver
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
-	"strings"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/iso9660"
 
 	"github.com/docker/machine/libmachine/drivers"
-	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/libmachine/mcnutils"
 	"github.com/pkg/errors"
 )
@@ -63,29 +63,21 @@ func publicSSHKeyPath(d *drivers.BaseDriver) string {
 	return d.GetSSHKeyPath() + ".pub"
 }
 
-func hdiutil(args ...string) error {
-	cmd := exec.Command("hdiutil", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	log.Debugf("executing: %v %v", cmd, strings.Join(args, " "))
-
-	err := cmd.Run()
+// extractFile copies f out of an ISO into dest.
+func extractFile(f *iso9660.File, dest string) error {
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
 	if err != nil {
 		return err
 	}
-
-	return nil
-}
-
-func readKernelCommandLine(path string) (string, error) {
-	inFile, err := os.Open(path)
-	if err != nil {
-		return "", err
+	if _, err := io.Copy(out, f.Open()); err != nil {
+		out.Close()
+		return err
 	}
-	defer inFile.Close()
+	return out.Close()
+}
 
-	scanner := bufio.NewScanner(inFile)
+func readKernelCommandLine(r io.Reader, path string) (string, error) {
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		if kernelCommandLineOptionsRegexp.Match(scanner.Bytes()) {
 			m := kernelCommandLineOptionsRegexp.FindSubmatch(scanner.Bytes())
@@ -0,0 +1,83 @@
+// Package iso9660 reads and writes ISO 9660 images with Joliet and Rock
+// Ridge extensions, such as the boot2docker ISO the kernel of machines is
+// extracted from and their cloud-init seed images, without relying on host
+// tools.
+package iso9660
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// SectorSize is the logical block size of the images.
+const SectorSize = 2048
+
+// systemAreaSectors are the sectors before the first volume descriptor.
+const systemAreaSectors = 16
+
+// Volume descriptor types.
+const (
+	volumePrimary       = 1
+	volumeSupplementary = 2
+	volumeTerminator    = 255
+)
+
+// Directory record flags.
+const (
+	flagDirectory = 0x02
+)
+
+// standardID identifies every volume descriptor.
+var standardID = []byte("CD001")
+
+// jolietEscape marks a supplementary volume descriptor as Joliet, UCS-2
+// level 3.
+var jolietEscape = []byte("%/E")
+
+// Rock Ridge is identified by an ER entry with these strings, as recorded
+// by mkisofs.
+const (
+	rockRidgeID          = "RRIP_1991A"
+	rockRidgeDescription = "THE ROCK RIDGE INTERCHANGE PROTOCOL PROVIDES SUPPORT FOR POSIX FILE SYSTEM SEMANTICS"
+	rockRidgeSource      = "PLEASE CONTACT DISC PUBLISHER FOR SPECIFICATION SOURCE.  SEE PUBLISHER IDENTIFIER IN PRIMARY VOLUME DESCRIPTOR FOR CONTACT INFORMATION."
+)
+
+// Record lengths.
+const (
+	directoryRecordLength = 33
+	maxRecordLength       = 255
+)
+
+func putBoth16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func putBoth32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+// putRecordTime writes t in the 7 byte format of directory records.
+func putRecordTime(b []byte, t time.Time) {
+	t = t.UTC()
+	b[0] = byte(t.Year() - 1900)
+	b[1] = byte(t.Month())
+	b[2] = byte(t.Day())
+	b[3] = byte(t.Hour())
+	b[4] = byte(t.Minute())
+	b[5] = byte(t.Second())
+	b[6] = 0
+}
+
+// putVolumeTime writes t in the 17 byte format of volume descriptors.
+func putVolumeTime(b []byte, t time.Time) {
+	t = t.UTC()
+	copy(b, t.Format("20060102150405")+"00")
+	b[16] = 0
+}
+
+// sectors returns how many sectors size bytes take up.
+func sectors(size int) uint32 {
+	return uint32((size + SectorSize - 1) / SectorSize)
+}
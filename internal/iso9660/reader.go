@@ -0,0 +1,429 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// maxContinuations bounds how many continuation areas are followed for a
+// single directory record, in case an image has them in a loop.
+const maxContinuations = 16
+
+// maxDepth bounds how deep directories are walked, in case an image nests
+// them without end through Rock Ridge relocations.
+const maxDepth = 64
+
+// Reader reads the files of an image. Names are those of the Rock Ridge
+// entries of the image if it has any, else those of its Joliet tree, else
+// its ISO 9660 names in lower case without their version, as Linux mounts
+// them.
+type Reader struct {
+	r         io.ReaderAt
+	root      directoryRecord
+	joliet    bool
+	rockRidge bool
+	// skip is the number of bytes at the start of every system use area
+	// before its entries, from the SP entry.
+	skip int
+}
+
+// File is a file or directory of an image.
+type File struct {
+	// Path is the slash separated path of the file from the root of the
+	// image, e.g. /boot/vmlinuz64.
+	Path    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+
+	r      io.ReaderAt
+	extent uint32
+}
+
+// IsDir reports whether f is a directory.
+func (f *File) IsDir() bool {
+	return f.Mode.IsDir()
+}
+
+// Open returns a reader of the content of f.
+func (f *File) Open() io.Reader {
+	return io.NewSectionReader(f.r, int64(f.extent)*SectorSize, f.Size)
+}
+
+// directoryRecord is the part of a directory record the Reader uses.
+type directoryRecord struct {
+	extent    uint32
+	size      uint32
+	flags     byte
+	time      time.Time
+	id        []byte
+	systemUse []byte
+}
+
+func parseDirectoryRecord(b []byte) (directoryRecord, error) {
+	if len(b) < directoryRecordLength {
+		return directoryRecord{}, errors.Errorf("Invalid directory record of %d bytes", len(b))
+	}
+	length := int(b[0])
+	if length < directoryRecordLength || length > len(b) || directoryRecordLength+int(b[32]) > length {
+		return directoryRecord{}, errors.Errorf("Invalid directory record of %d bytes", length)
+	}
+
+	idLength := int(b[32])
+	systemUse := directoryRecordLength + idLength
+	if idLength%2 == 0 {
+		systemUse++
+	}
+	if systemUse > length {
+		systemUse = length
+	}
+
+	return directoryRecord{
+		extent:    binary.LittleEndian.Uint32(b[2:]),
+		size:      binary.LittleEndian.Uint32(b[10:]),
+		time:      parseRecordTime(b[18:25]),
+		flags:     b[25],
+		id:        b[33 : 33+idLength],
+		systemUse: b[systemUse:length],
+	}, nil
+}
+
+// parseRecordTime parses the 7 byte time format of directory records.
+func parseRecordTime(b []byte) time.Time {
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
+
+// NewReader returns a Reader of the image r.
+func NewReader(r io.ReaderAt) (*Reader, error) {
+	reader := &Reader{r: r}
+
+	var primary, joliet *directoryRecord
+	descriptor := make([]byte, SectorSize)
+	for sector := int64(systemAreaSectors); ; sector++ {
+		if _, err := r.ReadAt(descriptor, sector*SectorSize); err != nil {
+			return nil, errors.Wrap(err, "Failed to read volume descriptor")
+		}
+		if !bytes.Equal(descriptor[1:6], standardID) {
+			return nil, errors.New("Not an ISO 9660 image")
+		}
+
+		if descriptor[0] == volumeTerminator {
+			break
+		}
+		if descriptor[0] != volumePrimary && descriptor[0] != volumeSupplementary {
+			continue
+		}
+		root, err := parseDirectoryRecord(descriptor[156:190])
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid root directory")
+		}
+		switch {
+		case descriptor[0] == volumePrimary && primary == nil:
+			primary = &root
+		case descriptor[0] == volumeSupplementary && isJolietEscape(descriptor[88:91]) && joliet == nil:
+			joliet = &root
+		}
+	}
+	if primary == nil {
+		return nil, errors.New("No primary volume descriptor")
+	}
+
+	// Rock Ridge images start the system use area of the first record of
+	// the root directory with an SP entry.
+	reader.root = *primary
+	records, err := reader.readDirectory(*primary)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 {
+		su := records[0].systemUse
+		if len(su) >= 7 && su[0] == 'S' && su[1] == 'P' && su[4] == 0xBE && su[5] == 0xEF {
+			reader.rockRidge = true
+			reader.skip = int(su[6])
+		}
+	}
+	if !reader.rockRidge && joliet != nil {
+		reader.root = *joliet
+		reader.joliet = true
+	}
+
+	return reader, nil
+}
+
+// isJolietEscape reports whether escape is one of the escape sequences of
+// the UCS-2 levels of Joliet.
+func isJolietEscape(escape []byte) bool {
+	return escape[0] == '%' && escape[1] == '/' && (escape[2] == '@' || escape[2] == 'C' || escape[2] == 'E')
+}
+
+// readDirectory reads the records of the directory dir.
+func (r *Reader) readDirectory(dir directoryRecord) ([]directoryRecord, error) {
+	data := make([]byte, dir.size)
+	if _, err := r.r.ReadAt(data, int64(dir.extent)*SectorSize); err != nil {
+		return nil, errors.Wrap(err, "Failed to read directory")
+	}
+
+	var records []directoryRecord
+	for offset := 0; offset < len(data); {
+		// Records don't cross sectors, which are padded with zeroes.
+		if data[offset] == 0 {
+			offset += SectorSize - offset%SectorSize
+			continue
+		}
+		record, err := parseDirectoryRecord(data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+		offset += int(data[offset])
+	}
+	return records, nil
+}
+
+// rockRidgeEntries is what the Reader uses of the Rock Ridge entries of a
+// directory record.
+type rockRidgeEntries struct {
+	name      string
+	mode      os.FileMode
+	hasMode   bool
+	relocated bool
+	childLink uint32
+	hasChild  bool
+}
+
+// readRockRidge parses the system use entries of record, following
+// continuation areas.
+func (r *Reader) readRockRidge(record directoryRecord) (rockRidgeEntries, error) {
+	var entries rockRidgeEntries
+	var name strings.Builder
+
+	su := record.systemUse
+	if len(su) >= r.skip {
+		su = su[r.skip:]
+	}
+	for continuations := 0; ; continuations++ {
+		var next []byte
+		for len(su) >= 4 {
+			length := int(su[2])
+			if length < 4 || length > len(su) {
+				break
+			}
+			entry := su[:length]
+			su = su[length:]
+
+			switch string(entry[:2]) {
+			case "NM":
+				// Bits 1 and 2 mark the names of the current and parent
+				// directories, which have no content.
+				if length > 5 && entry[4]&0x06 == 0 {
+					name.Write(entry[5:])
+				}
+			case "PX":
+				if length >= 12 {
+					entries.mode = posixMode(binary.LittleEndian.Uint32(entry[4:]))
+					entries.hasMode = true
+				}
+			case "RE":
+				entries.relocated = true
+			case "CL":
+				if length >= 12 {
+					entries.childLink = binary.LittleEndian.Uint32(entry[4:])
+					entries.hasChild = true
+				}
+			case "CE":
+				if length >= 28 {
+					sector := binary.LittleEndian.Uint32(entry[4:])
+					offset := binary.LittleEndian.Uint32(entry[12:])
+					size := binary.LittleEndian.Uint32(entry[20:])
+					next = make([]byte, size)
+					if _, err := r.r.ReadAt(next, int64(sector)*SectorSize+int64(offset)); err != nil {
+						return entries, errors.Wrap(err, "Failed to read continuation area")
+					}
+				}
+			case "ST":
+				su = nil
+			}
+		}
+
+		if next == nil {
+			break
+		}
+		if continuations == maxContinuations {
+			return entries, errors.New("Too many continuation areas")
+		}
+		su = next
+	}
+
+	entries.name = name.String()
+	return entries, nil
+}
+
+// posixMode returns the os.FileMode of the POSIX file mode m.
+func posixMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0o777)
+	switch m & 0o170000 {
+	case 0o040000:
+		mode |= os.ModeDir
+	case 0o120000:
+		mode |= os.ModeSymlink
+	}
+	return mode
+}
+
+// name returns the name of record in the tree the Reader reads.
+func (r *Reader) name(record directoryRecord) string {
+	if r.joliet {
+		units := make([]uint16, len(record.id)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(record.id[2*i:])
+		}
+		return trimVersion(string(utf16.Decode(units)), false)
+	}
+	return trimVersion(strings.ToLower(string(record.id)), true)
+}
+
+// trimVersion removes the version of a file identifier, and the dot of an
+// empty extension if dot is set.
+func trimVersion(id string, dot bool) string {
+	if i := strings.LastIndexByte(id, ';'); i >= 0 {
+		id = id[:i]
+	}
+	if dot {
+		id = strings.TrimSuffix(id, ".")
+	}
+	return id
+}
+
+// Walk calls fn for every file and directory of the image but its root,
+// depth first in the order of the directories. If fn returns fs.SkipDir for
+// a directory, its content is skipped, and for a file, the rest of the
+// directory it is in.
+func (r *Reader) Walk(fn func(f *File) error) error {
+	visited := map[uint32]bool{r.root.extent: true}
+	return r.walk("/", r.root, 0, visited, fn)
+}
+
+// walk walks the directory dir, at depth below the root. visited are the
+// extents of the directories walked so far, which are walked once so that
+// an image with a directory in itself can't loop.
+func (r *Reader) walk(dir string, record directoryRecord, depth int, visited map[uint32]bool, fn func(f *File) error) error {
+	records, err := r.readDirectory(record)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read %s", dir)
+	}
+
+	// Skip the records of the directory itself and of its parent.
+	if len(records) >= 2 {
+		records = records[2:]
+	}
+	for _, record := range records {
+		if record.flags&0x80 != 0 {
+			return errors.Errorf("%s has files of several extents, which aren't supported", dir)
+		}
+
+		f := &File{
+			Size:    int64(record.size),
+			ModTime: record.time,
+			r:       r.r,
+			extent:  record.extent,
+		}
+		name := r.name(record)
+		if record.flags&flagDirectory != 0 {
+			f.Mode = os.ModeDir | 0o555
+		} else {
+			f.Mode = 0o444
+		}
+
+		if r.rockRidge {
+			entries, err := r.readRockRidge(record)
+			if err != nil {
+				return errors.Wrapf(err, "Invalid Rock Ridge entries in %s", dir)
+			}
+			// Relocated directories are listed where their child link is.
+			if entries.relocated {
+				continue
+			}
+			if entries.name != "" {
+				name = entries.name
+			}
+			if entries.hasMode {
+				f.Mode = entries.mode
+			}
+			if entries.hasChild {
+				if record, err = r.relocatedDirectory(entries.childLink); err != nil {
+					return err
+				}
+				f.Mode |= os.ModeDir
+				f.extent, f.Size = record.extent, int64(record.size)
+			}
+		}
+		f.Path = path.Join(dir, name)
+
+		switch err := fn(f); {
+		case err == fs.SkipDir && f.IsDir():
+			continue
+		case err == fs.SkipDir:
+			return nil
+		case err != nil:
+			return err
+		}
+		if f.IsDir() {
+			if visited[record.extent] {
+				return errors.Errorf("%s is a directory already walked", f.Path)
+			}
+			if depth+1 == maxDepth {
+				return errors.Errorf("%s is nested deeper than %d directories", f.Path, maxDepth)
+			}
+			visited[record.extent] = true
+			if err := r.walk(f.Path, record, depth+1, visited, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// relocatedDirectory returns the record of the directory at extent, which
+// Rock Ridge moved there to keep the image shallow.
+func (r *Reader) relocatedDirectory(extent uint32) (directoryRecord, error) {
+	sector := make([]byte, SectorSize)
+	if _, err := r.r.ReadAt(sector, int64(extent)*SectorSize); err != nil {
+		return directoryRecord{}, errors.Wrap(err, "Failed to read relocated directory")
+	}
+	return parseDirectoryRecord(sector)
+}
+
+// Open returns the file at name, a slash separated path from the root of
+// the image.
+func (r *Reader) Open(name string) (*File, error) {
+	name = path.Clean("/" + name)
+
+	var found *File
+	err := r.Walk(func(f *File) error {
+		switch {
+		case f.Path == name:
+			found = f
+			return io.EOF
+		case f.IsDir() && !strings.HasPrefix(name, f.Path+"/"):
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if found == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return found, nil
+}
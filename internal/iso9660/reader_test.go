@@ -0,0 +1,173 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newFixture returns an image like boot2docker.iso, with Joliet and Rock
+// Ridge as given.
+func newFixture(t *testing.T, joliet, rockRidge bool) *bytes.Reader {
+	w := NewWriter("b2d-v20.10.17")
+	w.Joliet, w.RockRidge = joliet, rockRidge
+	files := map[string]string{
+		"boot/vmlinuz64":             strings.Repeat("kernel", 1000),
+		"boot/initrd.img":            "initrd",
+		"boot/isolinux/isolinux.cfg": "label boot2docker\n  append loglevel=3\n",
+		"version":                    "v20.10.17",
+		"user-data":                  "",
+	}
+	for name, data := range files {
+		if err := w.AddFile(name, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		msg       string
+		joliet    bool
+		rockRidge bool
+		wantPaths []string
+	}{
+		{
+			msg:       "rock ridge and joliet",
+			joliet:    true,
+			rockRidge: true,
+			wantPaths: []string{
+				"/boot", "/boot/initrd.img", "/boot/isolinux", "/boot/isolinux/isolinux.cfg",
+				"/boot/vmlinuz64", "/user-data", "/version",
+			},
+		}, {
+			msg:    "joliet",
+			joliet: true,
+			wantPaths: []string{
+				"/boot", "/boot/initrd.img", "/boot/isolinux", "/boot/isolinux/isolinux.cfg",
+				"/boot/vmlinuz64", "/user-data", "/version",
+			},
+		}, {
+			msg:       "rock ridge",
+			rockRidge: true,
+			wantPaths: []string{
+				"/boot", "/boot/initrd.img", "/boot/isolinux", "/boot/isolinux/isolinux.cfg",
+				"/boot/vmlinuz64", "/user-data", "/version",
+			},
+		}, {
+			msg: "iso 9660",
+			wantPaths: []string{
+				"/boot", "/boot/initrd.img", "/boot/isolinux", "/boot/isolinux/isolinux.cfg",
+				"/boot/vmlinuz64", "/user_data", "/version",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			r, err := NewReader(newFixture(t, tt.joliet, tt.rockRidge))
+			if err != nil {
+				t.Fatalf("Unexpected error reading image: %s", err)
+			}
+
+			var gotPaths []string
+			err = r.Walk(func(f *File) error {
+				gotPaths = append(gotPaths, f.Path)
+				if f.IsDir() != (f.Path == "/boot" || f.Path == "/boot/isolinux") {
+					t.Errorf("Unexpected mode %s of %s", f.Mode, f.Path)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error walking image: %s", err)
+			}
+			if !reflect.DeepEqual(tt.wantPaths, gotPaths) {
+				t.Errorf("Wanted paths %q but got %q", tt.wantPaths, gotPaths)
+			}
+
+			f, err := r.Open("boot/vmlinuz64")
+			if err != nil {
+				t.Fatalf("Unexpected error opening kernel: %s", err)
+			}
+			if data, err := io.ReadAll(f.Open()); err != nil || string(data) != strings.Repeat("kernel", 1000) {
+				t.Errorf("Unexpected kernel of %d bytes (%v)", len(data), err)
+			}
+
+			if _, err := r.Open("/boot/missing"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Wanted a not exist error but got %v", err)
+			}
+		})
+	}
+}
+
+func TestReader_SkipDir(t *testing.T) {
+	r, err := NewReader(newFixture(t, true, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotPaths []string
+	err = r.Walk(func(f *File) error {
+		gotPaths = append(gotPaths, f.Path)
+		if f.Path == "/boot/isolinux" || f.Path == "/user-data" {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error walking image: %s", err)
+	}
+	if want := []string{"/boot", "/boot/initrd.img", "/boot/isolinux", "/boot/vmlinuz64", "/user-data"}; !reflect.DeepEqual(want, gotPaths) {
+		t.Errorf("Wanted paths %q but got %q", want, gotPaths)
+	}
+}
+
+func TestNewReader_NotAnImage(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader(make([]byte, 20*SectorSize))); err == nil {
+		t.Error("Wanted an error reading an empty image")
+	}
+}
+
+func TestReader_DirectoryLoop(t *testing.T) {
+	image := newFixture(t, false, false)
+	r, err := NewReader(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boot, err := r.Open("/boot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Point the record of /boot/isolinux at /boot itself.
+	data := make([]byte, image.Size())
+	if _, err := image.ReadAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	start := int(boot.extent) * SectorSize
+	i := bytes.Index(data[start:start+int(boot.Size)], []byte("ISOLINUX"))
+	if i < 33 {
+		t.Fatal("No record of /boot/isolinux")
+	}
+	record := data[start+i-33:]
+	binary.LittleEndian.PutUint32(record[2:], boot.extent)
+	binary.BigEndian.PutUint32(record[6:], boot.extent)
+
+	if r, err = NewReader(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	err = r.Walk(func(f *File) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "/boot/isolinux is a directory already walked") {
+		t.Errorf("Wanted an error walking a directory loop but got %v", err)
+	}
+}
@@ -0,0 +1,548 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// The directory trees of an image: the primary one, with Rock Ridge
+// entries if enabled, and the Joliet one.
+const (
+	treePrimary = iota
+	treeJoliet
+	treeCount
+)
+
+// Writer builds an image in memory from the files added to it.
+type Writer struct {
+	// VolumeID is the label of the image, e.g. "cidata".
+	VolumeID string
+	// Joliet and RockRidge record names as they were added, which plain
+	// ISO 9660 restricts to upper case letters, digits and underscores.
+	// NewWriter enables both.
+	Joliet    bool
+	RockRidge bool
+	// ModTime is recorded for the image and every file in it.
+	ModTime time.Time
+
+	root *node
+}
+
+// node is a file or directory of the image.
+type node struct {
+	name     string
+	dir      bool
+	data     []byte
+	parent   *node
+	children []*node
+
+	// Set by build, per tree for directories. Files share their extent.
+	ids    [treeCount][]byte
+	extent [treeCount]uint32
+	size   [treeCount]uint32
+}
+
+// NewWriter returns a Writer for an image labelled volumeID.
+func NewWriter(volumeID string) *Writer {
+	w := &Writer{
+		VolumeID:  volumeID,
+		Joliet:    true,
+		RockRidge: true,
+		ModTime:   time.Now(),
+	}
+	w.root = &node{dir: true}
+	w.root.parent = w.root
+	return w
+}
+
+// AddFile adds a file holding data at name, a slash separated path from
+// the root of the image, along with any missing parent directories.
+func (w *Writer) AddFile(name string, data []byte) error {
+	dir, base := path.Split(path.Clean("/" + name))
+	if base == "" {
+		return errors.Errorf("Invalid file name %q", name)
+	}
+
+	parent, err := w.mkdirAll(dir)
+	if err != nil {
+		return err
+	}
+	if parent.child(base) != nil {
+		return errors.Errorf("%s already exists", name)
+	}
+	parent.children = append(parent.children, &node{name: base, data: data, parent: parent})
+	return nil
+}
+
+// AddDir adds a directory at name, along with any missing parents.
+func (w *Writer) AddDir(name string) error {
+	_, err := w.mkdirAll(name)
+	return err
+}
+
+func (w *Writer) mkdirAll(name string) (*node, error) {
+	dir := w.root
+	for _, part := range strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+
+		child := dir.child(part)
+		if child == nil {
+			child = &node{name: part, dir: true, parent: dir}
+			dir.children = append(dir.children, child)
+		} else if !child.dir {
+			return nil, errors.Errorf("%s is not a directory", child.name)
+		}
+		dir = child
+	}
+	return dir, nil
+}
+
+func (n *node) child(name string) *node {
+	for _, child := range n.children {
+		if child.name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// WriteTo writes the image to out.
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	image, err := w.build()
+	if err != nil {
+		return 0, err
+	}
+	n, err := out.Write(image)
+	return int64(n), err
+}
+
+// trees returns the directory trees the image has.
+func (w *Writer) trees() []int {
+	if w.Joliet {
+		return []int{treePrimary, treeJoliet}
+	}
+	return []int{treePrimary}
+}
+
+// build lays out and renders the image.
+func (w *Writer) build() ([]byte, error) {
+	if err := w.assignIDs(w.root); err != nil {
+		return nil, err
+	}
+
+	// Volume descriptors, then the path tables, the directories of every
+	// tree, the continuation area of the Rock Ridge ER entry and finally the
+	// file data. Streaming readers such as libarchive want continuation
+	// areas after the directories referring to them.
+	next := uint32(systemAreaSectors + len(w.trees()) + 1)
+
+	var dirs [treeCount][]*node
+	var pathTables [treeCount][]byte
+	var pathTableL, pathTableM [treeCount]uint32
+	for _, tree := range w.trees() {
+		dirs[tree] = w.directories(tree)
+		pathTables[tree] = pathTable(dirs[tree], tree, binary.LittleEndian)
+		size := sectors(len(pathTables[tree]))
+		pathTableL[tree] = next
+		pathTableM[tree] = next + size
+		next += 2 * size
+	}
+
+	for _, tree := range w.trees() {
+		for _, dir := range dirs[tree] {
+			size, err := w.directorySize(dir, tree)
+			if err != nil {
+				return nil, err
+			}
+			dir.extent[tree] = next
+			dir.size[tree] = size
+			next += sectors(int(size))
+		}
+	}
+
+	var continuation uint32
+	if w.RockRidge {
+		continuation = next
+		next++
+	}
+
+	for _, dir := range dirs[treePrimary] {
+		for _, child := range dir.children {
+			if child.dir {
+				continue
+			}
+			child.size[treePrimary] = uint32(len(child.data))
+			if len(child.data) > 0 {
+				child.extent[treePrimary] = next
+				next += sectors(len(child.data))
+			}
+		}
+	}
+
+	image := make([]byte, int(next)*SectorSize)
+
+	for _, tree := range w.trees() {
+		// Path tables are rendered again now that extents are assigned.
+		pathTables[tree] = pathTable(dirs[tree], tree, binary.LittleEndian)
+		copy(image[pathTableL[tree]*SectorSize:], pathTables[tree])
+		copy(image[pathTableM[tree]*SectorSize:], pathTable(dirs[tree], tree, binary.BigEndian))
+
+		for _, dir := range dirs[tree] {
+			w.writeDirectory(image[dir.extent[tree]*SectorSize:], dir, tree, continuation)
+		}
+
+		descriptor := image[(systemAreaSectors+tree)*SectorSize:]
+		w.writeVolumeDescriptor(descriptor, tree, next, uint32(len(pathTables[tree])), pathTableL[tree], pathTableM[tree])
+	}
+	terminator := image[(systemAreaSectors+len(w.trees()))*SectorSize:]
+	terminator[0] = volumeTerminator
+	copy(terminator[1:], standardID)
+	terminator[6] = 1
+
+	if w.RockRidge {
+		copy(image[continuation*SectorSize:], extensionReference())
+	}
+
+	for _, dir := range dirs[treePrimary] {
+		for _, child := range dir.children {
+			if !child.dir {
+				copy(image[child.extent[treePrimary]*SectorSize:], child.data)
+			}
+		}
+	}
+
+	return image, nil
+}
+
+// assignIDs sets the identifiers of the children of dir and their
+// descendants in every tree, and sorts them as ISO 9660 requires.
+func (w *Writer) assignIDs(dir *node) error {
+	seen := map[string]bool{}
+	for _, child := range dir.children {
+		// Names that only differ past what ISO 9660 keeps are told apart
+		// by a number, as the other trees record them in full.
+		id := isoID(child.name, child.dir, 0)
+		for i := 1; seen[id]; i++ {
+			id = isoID(child.name, child.dir, i)
+		}
+		seen[id] = true
+		child.ids[treePrimary] = []byte(id)
+
+		joliet := jolietID(child.name)
+		if w.Joliet && len(joliet) > 2*64 {
+			return errors.Errorf("%s is longer than the 64 characters of a Joliet name", child.name)
+		}
+		child.ids[treeJoliet] = joliet
+
+		if child.dir {
+			if err := w.assignIDs(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isoID returns the ISO 9660 identifier of a file or directory called name,
+// suffixed by n unless it is 0.
+func isoID(name string, dir bool, n int) string {
+	mapped := strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(name))
+
+	suffix := ""
+	if n > 0 {
+		suffix = "_" + strconv.Itoa(n)
+	}
+
+	if dir {
+		mapped = strings.ReplaceAll(mapped, ".", "_")
+		if len(mapped)+len(suffix) > 31 {
+			mapped = mapped[:31-len(suffix)]
+		}
+		return mapped + suffix
+	}
+
+	base, ext := mapped, ""
+	if i := strings.LastIndexByte(mapped, '.'); i >= 0 {
+		base, ext = mapped[:i], mapped[i+1:]
+	}
+	base = strings.ReplaceAll(base, ".", "_")
+	if len(ext) > 8 {
+		ext = ext[:8]
+	}
+	if len(base)+len(suffix)+len(ext) > 30 {
+		base = base[:30-len(suffix)-len(ext)]
+	}
+	return base + suffix + "." + ext + ";1"
+}
+
+// jolietID returns the Joliet identifier of name, in UCS-2.
+func jolietID(name string) []byte {
+	units := utf16.Encode([]rune(name))
+	id := make([]byte, 2*len(units))
+	for i, unit := range units {
+		binary.BigEndian.PutUint16(id[2*i:], unit)
+	}
+	return id
+}
+
+// sortedChildren returns the children of dir sorted by their identifier
+// in tree.
+func sortedChildren(dir *node, tree int) []*node {
+	children := append([]*node(nil), dir.children...)
+	sort.Slice(children, func(i, j int) bool {
+		return bytes.Compare(children[i].ids[tree], children[j].ids[tree]) < 0
+	})
+	return children
+}
+
+// directories returns the directories of tree in path table order, which
+// is breadth first.
+func (w *Writer) directories(tree int) []*node {
+	dirs := []*node{w.root}
+	for i := 0; i < len(dirs); i++ {
+		for _, child := range sortedChildren(dirs[i], tree) {
+			if child.dir {
+				dirs = append(dirs, child)
+			}
+		}
+	}
+	return dirs
+}
+
+// pathTable renders the path table of dirs in byte order.
+func pathTable(dirs []*node, tree int, order binary.ByteOrder) []byte {
+	numbers := map[*node]uint16{}
+	var table []byte
+	for i, dir := range dirs {
+		numbers[dir] = uint16(i + 1)
+
+		id := dir.ids[tree]
+		if dir.parent == dir {
+			id = []byte{0}
+		}
+		entry := make([]byte, 8+len(id)+len(id)%2)
+		entry[0] = byte(len(id))
+		order.PutUint32(entry[2:], dir.extent[tree])
+		order.PutUint16(entry[6:], numbers[dir.parent])
+		copy(entry[8:], id)
+		table = append(table, entry...)
+	}
+	return table
+}
+
+// recordLength returns the length of a directory record with an
+// identifier and system use area of the given lengths.
+func recordLength(idLength, systemUseLength int) int {
+	length := directoryRecordLength + idLength
+	if length%2 != 0 {
+		length++
+	}
+	return length + systemUseLength + systemUseLength%2
+}
+
+// record is a directory record to be written.
+type record struct {
+	id        []byte
+	node      *node
+	systemUse []byte
+}
+
+// records returns the records of dir in tree, starting with those of dir
+// itself and of its parent.
+func (w *Writer) records(dir *node, tree int, continuation uint32) []record {
+	records := []record{
+		{id: []byte{0}, node: dir},
+		{id: []byte{1}, node: dir.parent},
+	}
+	for _, child := range sortedChildren(dir, tree) {
+		records = append(records, record{id: child.ids[tree], node: child})
+	}
+
+	if tree == treePrimary && w.RockRidge {
+		for i := range records {
+			var systemUse []byte
+			if i == 0 && dir.parent == dir {
+				systemUse = append(systemUse, sharingProtocol()...)
+				systemUse = append(systemUse, continuationArea(continuation, len(extensionReference()))...)
+			}
+			systemUse = append(systemUse, posixAttributes(records[i].node.dir)...)
+			if i > 1 {
+				systemUse = append(systemUse, alternateName(records[i].node.name)...)
+			}
+			records[i].systemUse = systemUse
+		}
+	}
+	return records
+}
+
+// directorySize returns the size of the extent of dir in tree, in which
+// records don't cross sector boundaries.
+func (w *Writer) directorySize(dir *node, tree int) (uint32, error) {
+	offset := 0
+	for _, r := range w.records(dir, tree, 0) {
+		length := recordLength(len(r.id), len(r.systemUse))
+		if length > maxRecordLength {
+			return 0, errors.Errorf("Name %q is too long", r.node.name)
+		}
+		if offset%SectorSize+length > SectorSize {
+			offset += SectorSize - offset%SectorSize
+		}
+		offset += length
+	}
+	return sectors(offset) * SectorSize, nil
+}
+
+func (w *Writer) writeDirectory(b []byte, dir *node, tree int, continuation uint32) {
+	offset := 0
+	for _, r := range w.records(dir, tree, continuation) {
+		length := recordLength(len(r.id), len(r.systemUse))
+		if offset%SectorSize+length > SectorSize {
+			offset += SectorSize - offset%SectorSize
+		}
+		w.putRecord(b[offset:], r, tree)
+		offset += length
+	}
+}
+
+func (w *Writer) putRecord(b []byte, r record, tree int) {
+	b[0] = byte(recordLength(len(r.id), len(r.systemUse)))
+	if r.node.dir {
+		putBoth32(b[2:], r.node.extent[tree])
+		putBoth32(b[10:], r.node.size[tree])
+		b[25] = flagDirectory
+	} else {
+		putBoth32(b[2:], r.node.extent[treePrimary])
+		putBoth32(b[10:], r.node.size[treePrimary])
+	}
+	putRecordTime(b[18:], w.ModTime)
+	putBoth16(b[28:], 1)
+	b[32] = byte(len(r.id))
+	copy(b[33:], r.id)
+
+	offset := directoryRecordLength + len(r.id)
+	if offset%2 != 0 {
+		offset++
+	}
+	copy(b[offset:], r.systemUse)
+}
+
+func (w *Writer) writeVolumeDescriptor(b []byte, tree int, volumeSize, pathTableSize, pathTableL, pathTableM uint32) {
+	text := func(field []byte, s string) {
+		if tree == treeJoliet {
+			for i := 0; i+1 < len(field); i += 2 {
+				field[i], field[i+1] = 0, ' '
+			}
+			copy(field, jolietID(s))
+			return
+		}
+		for i := range field {
+			field[i] = ' '
+		}
+		copy(field, strings.ToUpper(s))
+	}
+
+	b[0] = volumePrimary
+	if tree == treeJoliet {
+		b[0] = volumeSupplementary
+		copy(b[88:], jolietEscape)
+	}
+	copy(b[1:], standardID)
+	b[6] = 1
+
+	text(b[8:40], "")
+	// The label is recorded as it is rather than in upper case, as
+	// cloud-init looks for cidata.
+	text(b[40:72], "")
+	copy(b[40:72], encodeVolumeID(w.VolumeID, tree))
+	putBoth32(b[80:], volumeSize)
+	putBoth16(b[120:], 1)
+	putBoth16(b[124:], 1)
+	putBoth16(b[128:], SectorSize)
+	putBoth32(b[132:], pathTableSize)
+	binary.LittleEndian.PutUint32(b[140:], pathTableL)
+	binary.BigEndian.PutUint32(b[148:], pathTableM)
+	w.putRecord(b[156:], record{id: []byte{0}, node: w.root}, tree)
+	for _, field := range [][]byte{b[190:318], b[318:446], b[446:574], b[574:702], b[702:739], b[739:776], b[776:813]} {
+		text(field, "")
+	}
+	putVolumeTime(b[813:], w.ModTime)
+	putVolumeTime(b[830:], w.ModTime)
+	copy(b[847:], "0000000000000000")
+	copy(b[864:], "0000000000000000")
+	b[881] = 1
+}
+
+// encodeVolumeID returns the volume identifier as recorded in tree.
+func encodeVolumeID(volumeID string, tree int) []byte {
+	if tree == treeJoliet {
+		id := jolietID(volumeID)
+		if len(id) > 32 {
+			id = id[:32]
+		}
+		return id
+	}
+	if len(volumeID) > 32 {
+		volumeID = volumeID[:32]
+	}
+	return []byte(volumeID)
+}
+
+// System Use Sharing Protocol and Rock Ridge entries.
+
+func sharingProtocol() []byte {
+	return []byte{'S', 'P', 7, 1, 0xBE, 0xEF, 0}
+}
+
+func continuationArea(sector uint32, length int) []byte {
+	entry := make([]byte, 28)
+	copy(entry, "CE")
+	entry[2], entry[3] = 28, 1
+	putBoth32(entry[4:], sector)
+	putBoth32(entry[12:], 0)
+	putBoth32(entry[20:], uint32(length))
+	return entry
+}
+
+func extensionReference() []byte {
+	entry := []byte{'E', 'R', 0, 1, byte(len(rockRidgeID)), byte(len(rockRidgeDescription)), byte(len(rockRidgeSource)), 1}
+	entry = append(entry, rockRidgeID...)
+	entry = append(entry, rockRidgeDescription...)
+	entry = append(entry, rockRidgeSource...)
+	entry[2] = byte(len(entry))
+	return entry
+}
+
+func posixAttributes(dir bool) []byte {
+	mode, links := uint32(0o100644), uint32(1)
+	if dir {
+		mode, links = 0o040755, 2
+	}
+
+	entry := make([]byte, 36)
+	copy(entry, "PX")
+	entry[2], entry[3] = 36, 1
+	putBoth32(entry[4:], mode)
+	putBoth32(entry[12:], links)
+	return entry
+}
+
+func alternateName(name string) []byte {
+	entry := []byte{'N', 'M', byte(5 + len(name)), 1, 0}
+	return append(entry, name...)
+}
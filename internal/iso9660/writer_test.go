@@ -0,0 +1,109 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestIsoID(t *testing.T) {
+	tests := []struct {
+		msg  string
+		name string
+		dir  bool
+		n    int
+		want string
+	}{
+		{
+			msg:  "file",
+			name: "user-data",
+			want: "USER_DATA.;1",
+		}, {
+			msg:  "file with extension",
+			name: "isolinux.cfg",
+			want: "ISOLINUX.CFG;1",
+		}, {
+			msg:  "file with several dots",
+			name: "vmlinuz-5.15.tar.gz",
+			want: "VMLINUZ_5_15_TAR.GZ;1",
+		}, {
+			msg:  "long file name",
+			name: "a-rather-long-file-name-number-one.txt",
+			n:    12,
+			want: "A_RATHER_LONG_FILE_NAME__12.TXT;1",
+		}, {
+			msg:  "directory",
+			name: "boot.d",
+			dir:  true,
+			want: "BOOT_D",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			if got := isoID(tt.name, tt.dir, tt.n); got != tt.want {
+				t.Errorf("Wanted %q but got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWriter(t *testing.T) {
+	w := NewWriter("cidata")
+	for name, data := range map[string]string{
+		"user-data":                  "#cloud-config\n",
+		"meta-data":                  "instance-id: default\n",
+		"boot/isolinux/isolinux.cfg": "append loglevel=3\n",
+	} {
+		if err := w.AddFile(name, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.AddFile("boot/isolinux", nil); err == nil {
+		t.Error("Wanted an error adding a file over a directory")
+	}
+
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	image := buf.Bytes()
+	if len(image)%SectorSize != 0 {
+		t.Fatalf("Image of %d bytes isn't made of whole sectors", len(image))
+	}
+
+	sector := func(n uint32) []byte {
+		return image[n*SectorSize : (n+1)*SectorSize]
+	}
+
+	primary := sector(systemAreaSectors)
+	if primary[0] != volumePrimary || !bytes.Equal(primary[1:6], standardID) {
+		t.Fatalf("Wanted a primary volume descriptor but got % x", primary[:8])
+	}
+	if label := string(bytes.TrimRight(primary[40:72], " ")); label != "cidata" {
+		t.Errorf("Wanted label cidata but got %q", label)
+	}
+	if size := binary.LittleEndian.Uint32(primary[80:]); int(size)*SectorSize != len(image) {
+		t.Errorf("Volume of %d sectors recorded for an image of %d", size, len(image)/SectorSize)
+	}
+
+	joliet := sector(systemAreaSectors + 1)
+	if joliet[0] != volumeSupplementary || !bytes.Equal(joliet[88:91], jolietEscape) {
+		t.Errorf("Wanted a Joliet volume descriptor but got % x", joliet[:8])
+	}
+	if sector(systemAreaSectors + 2)[0] != volumeTerminator {
+		t.Error("Wanted a volume descriptor set terminator")
+	}
+
+	root := sector(binary.LittleEndian.Uint32(primary[156+2:]))
+	for _, want := range [][]byte{
+		[]byte("USER_DATA.;1"),
+		[]byte("META_DATA.;1"),
+		[]byte("BOOT"),
+		alternateName("user-data"),
+		sharingProtocol(),
+	} {
+		if !bytes.Contains(root, want) {
+			t.Errorf("Wanted %q in the root directory", want)
+		}
+	}
+}
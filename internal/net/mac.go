@@ -1,6 +1,7 @@
 package driver
 
 import (
+	"crypto/rand"
 	"net"
 
 	"github.com/docker/machine/libmachine/log"
@@ -44,3 +45,15 @@ func (a *HardwareAddr) ToNetHardwareAddr() net.HardwareAddr {
 func FromNetHardwareAddr(a net.HardwareAddr) HardwareAddr {
 	return HardwareAddr{HardwareAddr: a}
 }
+
+// NewRandomLocallyAdministeredHardwareAddr returns a random unicast MAC
+// address with the locally administered bit set.
+func NewRandomLocallyAdministeredHardwareAddr() net.HardwareAddr {
+	addr := make(net.HardwareAddr, 6)
+	if _, err := rand.Read(addr); err != nil {
+		panic(err)
+	}
+
+	addr[0] = (addr[0] | 0x02) &^ 0x01
+	return addr
+}
@@ -0,0 +1,160 @@
+package vz
+
+import (
+	"io"
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnsupported is returned by backends that can't run virtual machines on
+// the current host.
+var ErrUnsupported = errors.New("virtual machines are not supported on this host")
+
+// ErrNoMemoryBalloon is returned when changing the memory of a virtual
+// machine without a memory balloon device.
+var ErrNoMemoryBalloon = errors.New("virtual machine has no memory balloon device")
+
+// ErrNoSocketDevice is returned when connecting to a virtual machine without
+// a virtio socket device.
+var ErrNoSocketDevice = errors.New("virtual machine has no socket device")
+
+// State is the state of a virtual machine. The values match those of
+// Virtualization.framework's VZVirtualMachineState.
+type State int
+
+const (
+	StateStopped State = iota
+	StateRunning
+	StatePaused
+	StateError
+	StateStarting
+	StatePausing
+	StateResuming
+)
+
+var stateNames = []string{
+	"Stopped",
+	"Running",
+	"Paused",
+	"Error",
+	"Starting",
+	"Pausing",
+	"Resuming",
+}
+
+func (s State) String() string {
+	if int(s) >= 0 && int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return "Unknown"
+}
+
+// Backend creates virtual machines on a hypervisor.
+type Backend interface {
+	// NewMachine validates spec and creates a virtual machine from it.
+	NewMachine(spec *MachineSpec) (Machine, error)
+}
+
+// Machine is a virtual machine created by a Backend.
+type Machine interface {
+	// Start boots the virtual machine, returning once it is running or
+	// failed to start.
+	Start() error
+	CanRequestStop() bool
+	// RequestStop asks the guest to shut down.
+	RequestStop() error
+	// Stop stops the virtual machine immediately, without involving the
+	// guest.
+	Stop() error
+	CanPause() bool
+	Pause() error
+	CanResume() bool
+	Resume() error
+	State() State
+	// SetTargetMemorySize asks the guest, through the memory balloon
+	// device, to use size bytes of memory. size must be a multiple of 1 MiB.
+	SetTargetMemorySize(size uint64) error
+	TargetMemorySize() (uint64, error)
+	// ConnectToPort connects to a vsock port the guest listens on.
+	ConnectToPort(port uint32) (net.Conn, error)
+	// ListenOnPort accepts the connections the guest makes to a vsock port
+	// of the host.
+	ListenOnPort(port uint32) (net.Listener, error)
+	// StateChangedNotify returns a channel receiving every new state of
+	// the virtual machine.
+	StateChangedNotify() <-chan State
+}
+
+// MachineSpec is the hardware of a virtual machine, as handed to a Backend.
+// Memory sizes are in bytes.
+type MachineSpec struct {
+	BootLoader LinuxBootLoader
+	// EFIBootLoader boots the machine through EFI instead of BootLoader
+	// if it is set.
+	EFIBootLoader   *EFIBootLoader
+	CPUs            uint
+	MemorySize      uint64
+	SerialPorts     []SerialPortDevice
+	NetworkDevices  []NetworkDevice
+	StorageDevices  []StorageDevice
+	DirectoryShares []DirectorySharingDevice
+	EntropyDevice   bool
+	// MemoryBalloonDevice adds a virtio traditional memory balloon device.
+	MemoryBalloonDevice bool
+	// SocketDevice adds a virtio socket device for ConnectToPort and
+	// ListenOnPort.
+	SocketDevice bool
+
+	// closers release the host side of devices once the machine stopped.
+	closers []io.Closer
+	// reboot watches the output of unix serial ports, if any, for the
+	// guest rebooting.
+	reboot *rebootWatcher
+}
+
+// LinuxBootLoader boots a Linux kernel directly.
+type LinuxBootLoader struct {
+	Kernel  string
+	Initrd  string
+	CmdLine string
+}
+
+// EFIBootLoader boots from the first bootable storage device through EFI
+// firmware, keeping its variables in the file VariableStore.
+type EFIBootLoader struct {
+	VariableStore string
+}
+
+// SerialPortDevice is a virtio console port. Output is written to the file
+// at Path if it is set, otherwise the port is attached to Read and Write.
+type SerialPortDevice struct {
+	Read   *os.File
+	Write  *os.File
+	Path   string
+	Append bool
+}
+
+// NetworkDevice is a virtio network device. It is bridged to the host
+// interface BridgeInterface if it is set, exchanges frames over the datagram
+// socket File if it is set, and is attached to the NAT network otherwise.
+type NetworkDevice struct {
+	MACAddress      net.HardwareAddr
+	BridgeInterface string
+	File            *os.File
+}
+
+// StorageDevice is a virtio block device backed by a disk image.
+type StorageDevice struct {
+	Path     string
+	ReadOnly bool
+}
+
+// DirectorySharingDevice is a virtio file system device sharing a single
+// host directory with the guest.
+type DirectorySharingDevice struct {
+	Tag       string
+	Directory string
+	ReadOnly  bool
+}
@@ -0,0 +1,262 @@
+//go:build darwin
+// +build darwin
+
+package vz
+
+import (
+	"log"
+	"sync"
+
+	"github.com/Code-Hex/vz"
+	"github.com/pkg/errors"
+)
+
+// NewBackend returns the Virtualization.framework backend.
+func NewBackend() Backend {
+	return codeHexBackend{}
+}
+
+type codeHexBackend struct{}
+
+func (codeHexBackend) NewMachine(spec *MachineSpec) (Machine, error) {
+	vzConfig, err := convertToVZ(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	validated, err := vzConfig.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid virtual machine configuration")
+	}
+	if !validated {
+		return nil, errors.New("Invalid virtual machine configuration")
+	}
+
+	m := newCodeHexMachine(vz.NewVirtualMachine(vzConfig))
+	spec.closers = append(spec.closers, m)
+	return m, nil
+}
+
+func convertToVZ(spec *MachineSpec) (*vz.VirtualMachineConfiguration, error) {
+	var bootLoader vz.BootLoader
+	if spec.EFIBootLoader != nil {
+		efiBootLoader, err := vz.NewEFIBootLoader(spec.EFIBootLoader.VariableStore)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to open EFI variable store %s", spec.EFIBootLoader.VariableStore)
+		}
+		bootLoader = efiBootLoader
+
+		log.Println("BootLoader: EFI, variable store:", spec.EFIBootLoader.VariableStore)
+	} else {
+		linuxBootLoader := vz.NewLinuxBootLoader(
+			spec.BootLoader.Kernel,
+			vz.WithCommandLine(spec.BootLoader.CmdLine),
+			vz.WithInitrd(spec.BootLoader.Initrd),
+		)
+		bootLoader = linuxBootLoader
+
+		log.Println("BootLoader:", linuxBootLoader)
+	}
+
+	vzConfig := vz.NewVirtualMachineConfiguration(
+		bootLoader,
+		spec.CPUs,
+		spec.MemorySize,
+	)
+
+	// console
+	var serialPorts []*vz.VirtioConsoleDeviceSerialPortConfiguration
+	for _, port := range spec.SerialPorts {
+		var attachment vz.SerialPortAttachment
+		var err error
+		if port.Path == "" {
+			attachment = vz.NewFileHandleSerialPortAttachment(port.Read, port.Write)
+		} else {
+			attachment, err = vz.NewFileSerialPortAttachment(port.Path, port.Append)
+			if err != nil {
+				return nil, err
+			}
+		}
+		serialPort := vz.NewVirtioConsoleDeviceSerialPortConfiguration(attachment)
+		serialPorts = append(serialPorts, serialPort)
+	}
+	vzConfig.SetSerialPortsVirtualMachineConfiguration(serialPorts)
+
+	// network
+	var networkDevices []*vz.VirtioNetworkDeviceConfiguration
+	for _, network := range spec.NetworkDevices {
+		var attachment vz.NetworkDeviceAttachment
+		switch {
+		case network.BridgeInterface != "":
+			bridgedNetwork, err := findBridgedNetwork(network.BridgeInterface)
+			if err != nil {
+				return nil, err
+			}
+			attachment = vz.NewBridgedNetworkDeviceAttachment(bridgedNetwork)
+		case network.File != nil:
+			attachment = vz.NewFileHandleNetworkDeviceAttachment(network.File)
+		default:
+			attachment = vz.NewNATNetworkDeviceAttachment()
+		}
+
+		networkDevice := vz.NewVirtioNetworkDeviceConfiguration(attachment)
+		networkDevice.SetMACAddress(vz.NewMACAddress(network.MACAddress))
+		networkDevices = append(networkDevices, networkDevice)
+	}
+	vzConfig.SetNetworkDevicesVirtualMachineConfiguration(networkDevices)
+
+	// entropy
+	if spec.EntropyDevice {
+		entropyConfig := vz.NewVirtioEntropyDeviceConfiguration()
+		vzConfig.SetEntropyDevicesVirtualMachineConfiguration([]*vz.VirtioEntropyDeviceConfiguration{
+			entropyConfig,
+		})
+	}
+
+	var storageDevices []vz.StorageDeviceConfiguration
+	for _, disk := range spec.StorageDevices {
+		diskImageAttachment, err := vz.NewDiskImageStorageDeviceAttachment(
+			disk.Path,
+			disk.ReadOnly,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+		storageDeviceConfig := vz.NewVirtioBlockDeviceConfiguration(diskImageAttachment)
+
+		storageDevices = append(storageDevices, storageDeviceConfig)
+	}
+	vzConfig.SetStorageDevicesVirtualMachineConfiguration(storageDevices)
+
+	// traditional memory balloon device which allows for managing guest memory.
+	if spec.MemoryBalloonDevice {
+		vzConfig.SetMemoryBalloonDevicesVirtualMachineConfiguration([]vz.MemoryBalloonDeviceConfiguration{
+			vz.NewVirtioTraditionalMemoryBalloonDeviceConfiguration(),
+		})
+	}
+
+	// socket device
+	if spec.SocketDevice {
+		vzConfig.SetSocketDevicesVirtualMachineConfiguration([]vz.SocketDeviceConfiguration{
+			vz.NewVirtioSocketDeviceConfiguration(),
+		})
+	}
+
+	var sharedDirectorieConfigs []vz.DirectorySharingDeviceConfiguration
+	for _, share := range spec.DirectoryShares {
+		sharedDirectory := vz.NewSharedDirectory(share.Directory, share.ReadOnly)
+		singleSharedDirectory := vz.NewSingleDirectoryShare(sharedDirectory)
+
+		sharedDirectoryConfig := vz.NewVirtioFileSystemDeviceConfiguration(share.Tag)
+		sharedDirectoryConfig.SetDirectoryShare(singleSharedDirectory)
+
+		sharedDirectorieConfigs = append(sharedDirectorieConfigs, sharedDirectoryConfig)
+	}
+	vzConfig.SetDirectorySharingDevicesVirtualMachineConfiguration(sharedDirectorieConfigs)
+
+	return vzConfig, nil
+}
+
+// codeHexMachine adapts a Code-Hex/vz virtual machine to Machine.
+type codeHexMachine struct {
+	vm     *vz.VirtualMachine
+	notify chan State
+	// done is closed by Close to stop relaying state changes, as
+	// Code-Hex/vz never closes its notification channel.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// socketMu serializes the calls that write the global maps Code-Hex/vz
+	// keeps of socket handlers without synchronization: SocketDevices
+	// registers a handler for every device object it builds, ConnectToPort
+	// replaces it and NewVirtioSocketListener adds one per listener.
+	socketMu sync.Mutex
+}
+
+func newCodeHexMachine(vm *vz.VirtualMachine) *codeHexMachine {
+	m := &codeHexMachine{
+		vm:     vm,
+		notify: make(chan State),
+		done:   make(chan struct{}),
+	}
+
+	changes := vm.StateChangedNotify()
+	go func() {
+		for {
+			select {
+			case state := <-changes:
+				select {
+				case m.notify <- State(state):
+				case <-m.done:
+					return
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+
+	return m
+}
+
+// Close stops relaying state changes of the virtual machine.
+func (m *codeHexMachine) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
+	return nil
+}
+
+func (m *codeHexMachine) Start() error {
+	var startError error
+	m.vm.Start(func(err error) {
+		startError = err
+	})
+	return startError
+}
+
+func (m *codeHexMachine) CanRequestStop() bool {
+	return m.vm.CanRequestStop()
+}
+
+func (m *codeHexMachine) RequestStop() error {
+	stopped, err := m.vm.RequestStop()
+	if err != nil {
+		return err
+	}
+	if !stopped {
+		return errors.New("Stop request was not delivered to the guest")
+	}
+	return nil
+}
+
+func (m *codeHexMachine) CanPause() bool {
+	return m.vm.CanPause()
+}
+
+func (m *codeHexMachine) Pause() error {
+	var pauseError error
+	m.vm.Pause(func(err error) {
+		pauseError = err
+	})
+	return pauseError
+}
+
+func (m *codeHexMachine) CanResume() bool {
+	return m.vm.CanResume()
+}
+
+func (m *codeHexMachine) Resume() error {
+	var resumeError error
+	m.vm.Resume(func(err error) {
+		resumeError = err
+	})
+	return resumeError
+}
+
+func (m *codeHexMachine) State() State {
+	return State(m.vm.State())
+}
+
+func (m *codeHexMachine) StateChangedNotify() <-chan State {
+	return m.notify
+}
@@ -0,0 +1,16 @@
+//go:build !darwin
+// +build !darwin
+
+package vz
+
+// NewBackend returns a backend that fails to create any virtual machine, as
+// Virtualization.framework is only available on macOS.
+func NewBackend() Backend {
+	return unsupportedBackend{}
+}
+
+type unsupportedBackend struct{}
+
+func (unsupportedBackend) NewMachine(spec *MachineSpec) (Machine, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,65 @@
+//go:build darwin
+// +build darwin
+
+package vz
+
+/*
+#cgo darwin CFLAGS: -x objective-c -fno-objc-arc
+#cgo darwin LDFLAGS: -lobjc -framework Foundation -framework Virtualization
+#include <stdbool.h>
+#import <Virtualization/Virtualization.h>
+
+// memoryBalloon returns the traditional memory balloon device of machine,
+// or nil. It must be called on the queue of machine.
+static VZVirtioTraditionalMemoryBalloonDevice *memoryBalloon(VZVirtualMachine *machine)
+{
+	for (VZMemoryBalloonDevice *device in machine.memoryBalloonDevices) {
+		if ([device isKindOfClass:[VZVirtioTraditionalMemoryBalloonDevice class]]) {
+			return (VZVirtioTraditionalMemoryBalloonDevice *)device;
+		}
+	}
+	return nil;
+}
+
+static bool setTargetMemorySize(void *machine, void *queue, unsigned long long size)
+{
+	__block bool found = false;
+	dispatch_sync((dispatch_queue_t)queue, ^{
+		VZVirtioTraditionalMemoryBalloonDevice *balloon = memoryBalloon((VZVirtualMachine *)machine);
+		if (balloon != nil) {
+			balloon.targetVirtualMachineMemorySize = size;
+			found = true;
+		}
+	});
+	return found;
+}
+
+static bool getTargetMemorySize(void *machine, void *queue, unsigned long long *size)
+{
+	__block bool found = false;
+	dispatch_sync((dispatch_queue_t)queue, ^{
+		VZVirtioTraditionalMemoryBalloonDevice *balloon = memoryBalloon((VZVirtualMachine *)machine);
+		if (balloon != nil) {
+			*size = balloon.targetVirtualMachineMemorySize;
+			found = true;
+		}
+	});
+	return found;
+}
+*/
+import "C"
+
+func (m *codeHexMachine) SetTargetMemorySize(size uint64) error {
+	if !C.setTargetMemorySize(m.vm.Ptr(), m.vm.DispatchQueue(), C.ulonglong(size)) {
+		return ErrNoMemoryBalloon
+	}
+	return nil
+}
+
+func (m *codeHexMachine) TargetMemorySize() (uint64, error) {
+	var size C.ulonglong
+	if !C.getTargetMemorySize(m.vm.Ptr(), m.vm.DispatchQueue(), &size) {
+		return 0, ErrNoMemoryBalloon
+	}
+	return uint64(size), nil
+}
@@ -0,0 +1,9 @@
+package vz
+
+// BridgeInterface is a host network interface that virtual machines can be
+// bridged to.
+type BridgeInterface struct {
+	// Identifier is the BSD name of the interface, e.g. "en0".
+	Identifier  string
+	DisplayName string
+}
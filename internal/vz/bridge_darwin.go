@@ -0,0 +1,124 @@
+//go:build darwin
+// +build darwin
+
+package vz
+
+/*
+#cgo darwin CFLAGS: -x objective-c -fno-objc-arc
+#cgo darwin LDFLAGS: -lobjc -framework Foundation -framework Virtualization
+#include <stdlib.h>
+#import <Virtualization/Virtualization.h>
+
+static int bridgedInterfaceCount(void)
+{
+	@autoreleasepool {
+		return (int)[VZBridgedNetworkInterface networkInterfaces].count;
+	}
+}
+
+// bridgedInterfaceAt returns a retained interface.
+static void *bridgedInterfaceAt(int i)
+{
+	@autoreleasepool {
+		return [[VZBridgedNetworkInterface networkInterfaces][i] retain];
+	}
+}
+
+static char *bridgedInterfaceIdentifier(void *iface)
+{
+	@autoreleasepool {
+		return strdup([((VZBridgedNetworkInterface *)iface).identifier UTF8String]);
+	}
+}
+
+static char *bridgedInterfaceDisplayName(void *iface)
+{
+	@autoreleasepool {
+		NSString *name = ((VZBridgedNetworkInterface *)iface).localizedDisplayName;
+		return strdup(name != nil ? [name UTF8String] : "");
+	}
+}
+
+static void releaseObject(void *object)
+{
+	[(id)object release];
+}
+*/
+import "C"
+
+import (
+	"runtime"
+	"unsafe"
+
+	"github.com/Code-Hex/vz"
+	"github.com/pkg/errors"
+)
+
+// bridgedNetwork implements the vz.BridgedNetwork that Code-Hex/vz can't
+// look up itself.
+type bridgedNetwork struct {
+	ptr  unsafe.Pointer
+	info BridgeInterface
+}
+
+var _ vz.BridgedNetwork = (*bridgedNetwork)(nil)
+
+func (n *bridgedNetwork) Ptr() unsafe.Pointer          { return n.ptr }
+func (n *bridgedNetwork) Identifier() string           { return n.info.Identifier }
+func (n *bridgedNetwork) LocalizedDisplayName() string { return n.info.DisplayName }
+func (n *bridgedNetwork) NetworkInterfaces() []vz.BridgedNetwork {
+	networks := bridgedNetworks()
+	result := make([]vz.BridgedNetwork, len(networks))
+	for i, network := range networks {
+		result[i] = network
+	}
+	return result
+}
+
+// bridgedNetworks returns the interfaces the hypervisor can bridge to.
+func bridgedNetworks() []*bridgedNetwork {
+	count := int(C.bridgedInterfaceCount())
+	networks := make([]*bridgedNetwork, 0, count)
+	for i := 0; i < count; i++ {
+		ptr := C.bridgedInterfaceAt(C.int(i))
+
+		identifier := C.bridgedInterfaceIdentifier(ptr)
+		displayName := C.bridgedInterfaceDisplayName(ptr)
+		network := &bridgedNetwork{
+			ptr: ptr,
+			info: BridgeInterface{
+				Identifier:  C.GoString(identifier),
+				DisplayName: C.GoString(displayName),
+			},
+		}
+		C.free(unsafe.Pointer(identifier))
+		C.free(unsafe.Pointer(displayName))
+
+		runtime.SetFinalizer(network, func(n *bridgedNetwork) {
+			C.releaseObject(n.ptr)
+		})
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// findBridgedNetwork returns the interface named identifier.
+func findBridgedNetwork(identifier string) (*bridgedNetwork, error) {
+	for _, network := range bridgedNetworks() {
+		if network.info.Identifier == identifier {
+			return network, nil
+		}
+	}
+	return nil, errors.Errorf("Unable to bridge to interface %s", identifier)
+}
+
+// BridgeInterfaces returns the host interfaces that virtual machines can be
+// bridged to.
+func BridgeInterfaces() ([]BridgeInterface, error) {
+	networks := bridgedNetworks()
+	interfaces := make([]BridgeInterface, len(networks))
+	for i, network := range networks {
+		interfaces[i] = network.info
+	}
+	return interfaces, nil
+}
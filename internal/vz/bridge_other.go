@@ -0,0 +1,10 @@
+//go:build !darwin
+// +build !darwin
+
+package vz
+
+// BridgeInterfaces returns the host interfaces that virtual machines can be
+// bridged to.
+func BridgeInterfaces() ([]BridgeInterface, error) {
+	return nil, ErrUnsupported
+}
@@ -1,154 +1,487 @@
 package vz
 
 import (
-	"log"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/brholstein/docker-machine-driver-vz/internal/console"
 	vznet "github.com/brholstein/docker-machine-driver-vz/internal/net"
-
-	"github.com/Code-Hex/vz"
+	"github.com/pkg/errors"
 )
 
+// VirtualMachineConfig describes a virtual machine to be run by the vz
+// launcher. Memory is expressed in MiB.
 type VirtualMachineConfig struct {
-	Kernel            string
-	Initrd            string
-	CmdLine           string
-	CPUs              uint
-	Memory            uint
-	Disks             []VirtualMachineDiskConfig
-	NetworkInterfaces []VirtualMachineNetworkInterface
-	SharedDirectories []VirtualMachineSharedDirectory
-	// TODO: find a better way to handle this
-	SerialPorts []string
+	// BootMode selects how the guest is booted, defaulting to linux.
+	BootMode BootMode `json:"bootMode,omitempty"`
+	// EFIVariableStore is the file keeping the EFI variables of the guest
+	// across restarts in efi boot mode. It is created if it doesn't exist.
+	EFIVariableStore string `json:"efiVariableStore,omitempty"`
+
+	Kernel            string                           `json:"kernel"`
+	Initrd            string                           `json:"initrd"`
+	CmdLine           string                           `json:"cmdLine"`
+	CPUs              uint                             `json:"cpus"`
+	Memory            uint                             `json:"memory"`
+	Disks             []VirtualMachineDiskConfig       `json:"disks,omitempty"`
+	NetworkInterfaces []VirtualMachineNetworkInterface `json:"networkInterfaces,omitempty"`
+	SharedDirectories []VirtualMachineSharedDirectory  `json:"sharedDirectories,omitempty"`
+	SerialPorts       []VirtualMachineSerialPort       `json:"serialPorts,omitempty"`
+	// FirstBootCmdLine replaces CmdLine on the first boot of the launcher
+	// only, so that a guest rebooting or restarted by the launcher doesn't
+	// see options meant for provisioning it, such as ignition.firstboot.
+	FirstBootCmdLine string `json:"firstBootCmdLine,omitempty"`
+	// MemoryBalloon allows the memory of the guest to be reduced below
+	// Memory while it runs.
+	MemoryBalloon bool `json:"memoryBalloon,omitempty"`
+	// PortForwards relay host connections to guest ports through
+	// vz-agent, over a virtio socket device.
+	PortForwards []VirtualMachinePortForward `json:"portForwards,omitempty"`
+	// RestartPolicy makes the launcher restart the virtual machine when it
+	// stops by itself. It is never restarted if RestartPolicy is nil.
+	RestartPolicy *VirtualMachineRestartPolicy `json:"restartPolicy,omitempty"`
+	// Watchdog makes the launcher track the health of the guest by the
+	// heartbeats vz-agent sends over a virtio socket device.
+	Watchdog *VirtualMachineWatchdog `json:"watchdog,omitempty"`
+}
+
+// VirtualMachineWatchdog marks a guest unhealthy once it sent no heartbeat
+// for Timeout seconds, and stops and starts it again if Restart is set.
+// Guests are not marked unhealthy before their first heartbeat.
+type VirtualMachineWatchdog struct {
+	Timeout uint `json:"timeout"`
+	Restart bool `json:"restart,omitempty"`
+}
+
+// RestartPolicyMode selects which stops of a virtual machine the launcher
+// restarts it after.
+type RestartPolicyMode string
+
+const (
+	// RestartNo never restarts the virtual machine.
+	RestartNo RestartPolicyMode = "no"
+	// RestartOnFailure restarts the virtual machine after it failed, see
+	// ExitReason.IsFailure.
+	RestartOnFailure RestartPolicyMode = "on-failure"
+	// RestartAlways also restarts the virtual machine after the guest shut
+	// down. Stops requested from the host are never restarted after.
+	RestartAlways RestartPolicyMode = "always"
+)
+
+// Defaults of VirtualMachineRestartPolicy.
+const (
+	DefaultRestartBackoff           = 1
+	DefaultRestartMaxBackoff        = 300
+	DefaultRestartCrashLoopRestarts = 5
+	DefaultRestartCrashLoopWindow   = 600
+)
+
+// VirtualMachineRestartPolicy describes when and how fast the launcher
+// restarts a virtual machine that stopped. Durations are in seconds, and
+// zero values other than MaxRetries select the defaults.
+type VirtualMachineRestartPolicy struct {
+	Mode RestartPolicyMode `json:"mode"`
+	// MaxRetries is the most restarts a launcher does since the virtual
+	// machine last ran for CrashLoopWindow, or 0 for no limit.
+	MaxRetries uint `json:"maxRetries,omitempty"`
+	// Backoff is the delay before a restart, doubled for every other
+	// restart within CrashLoopWindow, up to MaxBackoff.
+	Backoff    uint `json:"backoff,omitempty"`
+	MaxBackoff uint `json:"maxBackoff,omitempty"`
+	// CrashLoopRestarts restarts within CrashLoopWindow are a crash loop,
+	// after which the virtual machine is left stopped.
+	CrashLoopRestarts uint `json:"crashLoopRestarts,omitempty"`
+	CrashLoopWindow   uint `json:"crashLoopWindow,omitempty"`
+}
+
+// WithDefaults returns policy with its zero values replaced by defaults.
+func (policy VirtualMachineRestartPolicy) WithDefaults() VirtualMachineRestartPolicy {
+	if policy.Backoff == 0 {
+		policy.Backoff = DefaultRestartBackoff
+	}
+	if policy.MaxBackoff == 0 {
+		policy.MaxBackoff = DefaultRestartMaxBackoff
+	}
+	if policy.CrashLoopRestarts == 0 {
+		policy.CrashLoopRestarts = DefaultRestartCrashLoopRestarts
+	}
+	if policy.CrashLoopWindow == 0 {
+		policy.CrashLoopWindow = DefaultRestartCrashLoopWindow
+	}
+	return policy
+}
+
+// Restarts reports whether the policy restarts a virtual machine that
+// stopped because of reason.
+func (policy VirtualMachineRestartPolicy) Restarts(reason ExitReason) bool {
+	switch policy.Mode {
+	case RestartOnFailure:
+		return reason.IsFailure()
+	case RestartAlways:
+		return reason.IsFailure() || reason == ExitReasonGuestShutdown
+	}
+	return false
+}
+
+// BootMode is how a virtual machine is booted.
+type BootMode string
+
+const (
+	// BootModeLinux boots Kernel directly, with Initrd and CmdLine.
+	BootModeLinux BootMode = "linux"
+	// BootModeEFI boots through EFI firmware from the first bootable disk.
+	BootModeEFI BootMode = "efi"
+)
+
+// bootMode returns BootMode, or its default.
+func (config *VirtualMachineConfig) bootMode() BootMode {
+	if config.BootMode == "" {
+		return BootModeLinux
+	}
+	return config.BootMode
 }
 
 type VirtualMachineDiskConfig struct {
-	Path     string
-	ReadOnly bool
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"readOnly"`
 }
 
+// NetworkAttachment selects what a network interface is connected to.
+type NetworkAttachment string
+
+const (
+	// NetworkNAT connects the interface to the NAT network of the host.
+	NetworkNAT NetworkAttachment = "nat"
+	// NetworkBridged bridges the interface to the host interface
+	// BridgeInterface.
+	NetworkBridged NetworkAttachment = "bridged"
+	// NetworkFileHandle exchanges the raw frames of the interface with
+	// the Unix datagram socket at Socket, e.g. of a user space switch.
+	NetworkFileHandle NetworkAttachment = "file-handle"
+)
+
 type VirtualMachineNetworkInterface struct {
-	BridgeInterface string
-	MACAddress      vznet.HardwareAddr
+	// Type defaults to bridged if BridgeInterface is set, and to nat
+	// otherwise, as in configs written before it was added.
+	Type            NetworkAttachment  `json:"type,omitempty"`
+	BridgeInterface string             `json:"bridgeInterface,omitempty"`
+	Socket          string             `json:"socket,omitempty"`
+	MACAddress      vznet.HardwareAddr `json:"macAddress"`
+}
+
+// Attachment returns Type, or its default.
+func (network VirtualMachineNetworkInterface) Attachment() NetworkAttachment {
+	if network.Type != "" {
+		return network.Type
+	}
+	if network.BridgeInterface != "" {
+		return NetworkBridged
+	}
+	return NetworkNAT
+}
+
+// ParseNetworkInterface parses "nat", "bridged:<interface>" or
+// "file-handle:<socket path>". The MAC address is left unset.
+func ParseNetworkInterface(s string) (VirtualMachineNetworkInterface, error) {
+	attachment, arg := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		attachment, arg = s[:i], s[i+1:]
+	}
+
+	switch NetworkAttachment(attachment) {
+	case NetworkNAT:
+		if arg != "" {
+			return VirtualMachineNetworkInterface{}, errors.Errorf("Invalid network interface %q: nat takes no argument", s)
+		}
+		return VirtualMachineNetworkInterface{Type: NetworkNAT}, nil
+	case NetworkBridged:
+		if arg == "" {
+			return VirtualMachineNetworkInterface{}, errors.Errorf("Invalid network interface %q: no host interface to bridge to", s)
+		}
+		return VirtualMachineNetworkInterface{Type: NetworkBridged, BridgeInterface: arg}, nil
+	case NetworkFileHandle:
+		if arg == "" {
+			return VirtualMachineNetworkInterface{}, errors.Errorf("Invalid network interface %q: no socket", s)
+		}
+		return VirtualMachineNetworkInterface{Type: NetworkFileHandle, Socket: arg}, nil
+	}
+	return VirtualMachineNetworkInterface{}, errors.Errorf("Invalid network interface %q: must be nat, bridged:<interface> or file-handle:<socket>", s)
 }
 
 type VirtualMachineSharedDirectory struct {
-	Directory string
-	Tag       string
+	Directory string `json:"directory"`
+	Tag       string `json:"tag"`
 }
 
-func (config *VirtualMachineConfig) ConvertToVZ() (*vz.VirtualMachineConfiguration, error) {
-	bootLoader := vz.NewLinuxBootLoader(
-		config.Kernel,
-		vz.WithCommandLine(config.CmdLine),
-		vz.WithInitrd(config.Initrd),
-	)
+// VirtualMachinePortForward accepts connections on a host TCP address or
+// Unix socket and relays them to a guest TCP port.
+type VirtualMachinePortForward struct {
+	// Network is "tcp" or "unix".
+	Network   string `json:"network"`
+	Address   string `json:"address"`
+	GuestPort uint16 `json:"guestPort"`
+}
 
-	log.Println("BootLoader:", bootLoader)
+// ParsePortForward parses "[tcp:|unix:]<address>:<guest port>", where a
+// TCP address without a host listens on localhost, e.g. "8080:80",
+// "0.0.0.0:8080:80" or "unix:/tmp/docker.sock:2375".
+func ParsePortForward(s string) (VirtualMachinePortForward, error) {
+	forward := VirtualMachinePortForward{Network: "tcp"}
 
-	vzConfig := vz.NewVirtualMachineConfiguration(
-		bootLoader,
-		config.CPUs,
-		uint64(config.Memory*1024*1024),
-	)
+	rest := s
+	for _, network := range []string{"tcp", "unix"} {
+		if strings.HasPrefix(rest, network+":") {
+			forward.Network = network
+			rest = strings.TrimPrefix(rest, network+":")
+		}
+	}
+
+	i := strings.LastIndex(rest, ":")
+	if i < 0 {
+		return forward, errors.Errorf("Invalid port forward %q: no guest port", s)
+	}
+	guestPort, err := strconv.ParseUint(rest[i+1:], 10, 16)
+	if err != nil {
+		return forward, errors.Errorf("Invalid port forward %q: bad guest port %q", s, rest[i+1:])
+	}
+	forward.GuestPort = uint16(guestPort)
+	forward.Address = rest[:i]
+
+	if forward.Network == "tcp" && !strings.Contains(forward.Address, ":") {
+		forward.Address = net.JoinHostPort("127.0.0.1", forward.Address)
+	}
+	return forward, nil
+}
+
+// SerialPortType selects what a serial port is attached to on the host.
+type SerialPortType string
+
+const (
+	// SerialPortFile writes guest output to the file at Path.
+	SerialPortFile SerialPortType = "file"
+	// SerialPortStdio attaches the port to the standard input and output
+	// of the launcher.
+	SerialPortStdio SerialPortType = "stdio"
+	// SerialPortPTY attaches the port to a new pseudo-terminal, linked
+	// from Path if it is set.
+	SerialPortPTY SerialPortType = "pty"
+	// SerialPortUnix serves the port on a Unix socket at Path, which
+	// `vz console` attaches to.
+	SerialPortUnix SerialPortType = "unix"
+)
+
+type VirtualMachineSerialPort struct {
+	Type SerialPortType `json:"type"`
+	Path string         `json:"path,omitempty"`
+	// Append keeps the existing contents of a file port.
+	Append bool `json:"append,omitempty"`
+	// Log keeps a copy of the output of a unix port.
+	Log *VirtualMachineSerialLog `json:"log,omitempty"`
+}
+
+// VirtualMachineSerialLog is a log file rotated once it reaches MaxSize
+// bytes, keeping MaxFiles previous generations next to it.
+type VirtualMachineSerialLog struct {
+	Path     string `json:"path"`
+	MaxSize  int64  `json:"maxSize"`
+	MaxFiles int    `json:"maxFiles"`
+}
+
+// UnmarshalJSON also accepts the string form of earlier configs, where "-"
+// is stdio and anything else is the path of a file.
+func (port *VirtualMachineSerialPort) UnmarshalJSON(data []byte) error {
+	var legacy string
+	if err := json.Unmarshal(data, &legacy); err == nil {
+		if legacy == "-" {
+			*port = VirtualMachineSerialPort{Type: SerialPortStdio}
+		} else {
+			*port = VirtualMachineSerialPort{Type: SerialPortFile, Path: legacy}
+		}
+		return nil
+	}
+
+	// serialPort has no UnmarshalJSON, avoiding the recursion.
+	type serialPort VirtualMachineSerialPort
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*serialPort)(port))
+}
+
+// FirstBoot returns the config of the first boot of the virtual machine,
+// booted with FirstBootCmdLine if it is set.
+func (config *VirtualMachineConfig) FirstBoot() *VirtualMachineConfig {
+	if config.FirstBootCmdLine == "" {
+		return config
+	}
+	firstBoot := *config
+	firstBoot.CmdLine = config.FirstBootCmdLine
+	return &firstBoot
+}
+
+// Spec translates config into the hardware of a virtual machine.
+func (config *VirtualMachineConfig) Spec() (*MachineSpec, error) {
+	spec := &MachineSpec{
+		CPUs:                config.CPUs,
+		MemorySize:          uint64(config.Memory) * 1024 * 1024,
+		EntropyDevice:       true,
+		MemoryBalloonDevice: config.MemoryBalloon,
+		SocketDevice:        len(config.PortForwards) > 0 || config.Watchdog != nil,
+	}
+
+	if config.bootMode() == BootModeEFI {
+		spec.EFIBootLoader = &EFIBootLoader{VariableStore: config.EFIVariableStore}
+	} else {
+		spec.BootLoader = LinuxBootLoader{
+			Kernel:  config.Kernel,
+			Initrd:  config.Initrd,
+			CmdLine: config.CmdLine,
+		}
+	}
 
 	// console
-	var serialPorts []*vz.VirtioConsoleDeviceSerialPortConfiguration
 	for _, port := range config.SerialPorts {
-		var attachment vz.SerialPortAttachment
-		var err error
-		if port == "-" {
-			attachment = vz.NewFileHandleSerialPortAttachment(os.Stdin, os.Stdout)
-		} else {
-			attachment, err = vz.NewFileSerialPortAttachment(port, false)
-			if err != nil {
-				return nil, err
-			}
+		device, err := spec.openSerialPort(port)
+		if err != nil {
+			spec.Close()
+			return nil, err
 		}
-		serialPort := vz.NewVirtioConsoleDeviceSerialPortConfiguration(attachment)
-		serialPorts = append(serialPorts, serialPort)
+		spec.SerialPorts = append(spec.SerialPorts, device)
 	}
-	vzConfig.SetSerialPortsVirtualMachineConfiguration(serialPorts)
-	// serialPortAttachment := vz.NewFileHandleSerialPortAttachment(os.Stdin, os.Stdout)
-	// consoleConfig := vz.NewVirtioConsoleDeviceSerialPortConfiguration(serialPortAttachment)
-	// config.SetSerialPortsVirtualMachineConfiguration([]*vz.VirtioConsoleDeviceSerialPortConfiguration{
-	//	consoleConfig,
-	// })
 
 	// network
-	var networkDevices []*vz.VirtioNetworkDeviceConfiguration
 	for _, network := range config.NetworkInterfaces {
-		var attachment vz.NetworkDeviceAttachment
-
-		/*
-			if network.BridgeInterface {
-				net.InterfaceByName()
-				bridgedNetwork = vz.BridgedNetwork
-				attachment = vz.NewBridgedNetworkDeviceAttachment()
-
-			} else {
-		*/
-		attachment = vz.NewNATNetworkDeviceAttachment()
-		/*
+		macAddr := network.MACAddress.ToNetHardwareAddr()
+		if macAddr == nil {
+			macAddr = vznet.NewRandomLocallyAdministeredHardwareAddr()
+		}
+
+		device := NetworkDevice{MACAddress: macAddr}
+		switch network.Attachment() {
+		case NetworkBridged:
+			device.BridgeInterface = network.BridgeInterface
+		case NetworkFileHandle:
+			file, err := spec.openNetworkSocket(network.Socket)
+			if err != nil {
+				spec.Close()
+				return nil, err
 			}
-		*/
+			device.File = file
+		}
+		spec.NetworkDevices = append(spec.NetworkDevices, device)
+	}
 
-		networkDevice := vz.NewVirtioNetworkDeviceConfiguration(attachment)
+	for _, disk := range config.Disks {
+		spec.StorageDevices = append(spec.StorageDevices, StorageDevice{
+			Path:     disk.Path,
+			ReadOnly: disk.ReadOnly,
+		})
+	}
 
-		var macAddr *vz.MACAddress
-		if hwAddr := network.MACAddress.ToNetHardwareAddr(); hwAddr != nil {
-			macAddr = vz.NewMACAddress(hwAddr)
-		} else {
-			macAddr = vz.NewRandomLocallyAdministeredMACAddress()
-		}
-		networkDevice.SetMACAddress(macAddr)
-		networkDevices = append(networkDevices, networkDevice)
+	for _, share := range config.SharedDirectories {
+		spec.DirectoryShares = append(spec.DirectoryShares, DirectorySharingDevice{
+			Tag:       share.Tag,
+			Directory: share.Directory,
+		})
 	}
-	vzConfig.SetNetworkDevicesVirtualMachineConfiguration(networkDevices)
 
-	// entropy
-	entropyConfig := vz.NewVirtioEntropyDeviceConfiguration()
-	vzConfig.SetEntropyDevicesVirtualMachineConfiguration([]*vz.VirtioEntropyDeviceConfiguration{
-		entropyConfig,
-	})
+	return spec, nil
+}
 
-	var storageDevices []vz.StorageDeviceConfiguration
-	for _, disk := range config.Disks {
-		diskImageAttachment, err := vz.NewDiskImageStorageDeviceAttachment(
-			disk.Path,
-			disk.ReadOnly,
-		)
+// openSerialPort creates the host side of port. Resources it creates are
+// released by Close.
+func (spec *MachineSpec) openSerialPort(port VirtualMachineSerialPort) (SerialPortDevice, error) {
+	switch port.Type {
+	case SerialPortStdio:
+		return SerialPortDevice{Read: os.Stdin, Write: os.Stdout}, nil
+	case SerialPortPTY:
+		pty, err := console.OpenPTY(port.Path)
+		if err != nil {
+			return SerialPortDevice{}, err
+		}
+		spec.closers = append(spec.closers, pty)
+		return SerialPortDevice{Read: pty.Master, Write: pty.Master}, nil
+	case SerialPortUnix:
+		if spec.reboot == nil {
+			spec.reboot = &rebootWatcher{}
+		}
 
+		// The reboot watcher comes first, as the log is given up on
+		// after its first error.
+		log := io.Writer(spec.reboot)
+		if port.Log != nil {
+			logFile, err := console.OpenRotatingFile(port.Log.Path, port.Log.MaxSize, port.Log.MaxFiles)
+			if err != nil {
+				return SerialPortDevice{}, err
+			}
+			spec.closers = append(spec.closers, logFile)
+			log = io.MultiWriter(spec.reboot, logFile)
+		}
+
+		server, err := console.Listen(port.Path, log)
 		if err != nil {
-			return nil, err
+			return SerialPortDevice{}, err
 		}
-		storageDeviceConfig := vz.NewVirtioBlockDeviceConfiguration(diskImageAttachment)
+		spec.closers = append(spec.closers, server)
+		read, write := server.Files()
+		return SerialPortDevice{Read: read, Write: write}, nil
+	default:
+		return SerialPortDevice{Path: port.Path, Append: port.Append}, nil
+	}
+}
+
+// openNetworkSocket connects a Unix datagram socket to the socket at path,
+// returning the file to attach to a network device. Resources it creates
+// are released by Close.
+func (spec *MachineSpec) openNetworkSocket(path string) (*os.File, error) {
+	// The peer needs an address to send frames to.
+	local := filepath.Join(os.TempDir(), fmt.Sprintf("vz-%d-%d.sock", os.Getpid(), len(spec.closers)))
+	os.Remove(local)
 
-		storageDevices = append(storageDevices, storageDeviceConfig)
+	conn, err := net.DialUnix("unixgram",
+		&net.UnixAddr{Name: local, Net: "unixgram"},
+		&net.UnixAddr{Name: path, Net: "unixgram"},
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to connect to network socket %s", path)
 	}
-	vzConfig.SetStorageDevicesVirtualMachineConfiguration(storageDevices)
+	defer conn.Close()
 
-	// traditional memory balloon device which allows for managing guest memory. (optional)
-	// vzConfig.SetMemoryBalloonDevicesVirtualMachineConfiguration([]vz.MemoryBalloonDeviceConfiguration{
-	// 	vz.NewVirtioTraditionalMemoryBalloonDeviceConfiguration(),
-	// })
+	// Buffer sizes recommended for VZFileHandleNetworkDeviceAttachment.
+	conn.SetWriteBuffer(1024 * 1024)
+	conn.SetReadBuffer(4 * 1024 * 1024)
 
-	// socket device (optional)
-	// vzConfig.SetSocketDevicesVirtualMachineConfiguration([]vz.SocketDeviceConfiguration{
-	// 	vz.NewVirtioSocketDeviceConfiguration(),
-	// })
+	file, err := conn.File()
+	if err != nil {
+		os.Remove(local)
+		return nil, errors.Wrapf(err, "Failed to connect to network socket %s", path)
+	}
+	spec.closers = append(spec.closers, file, removeOnClose(local))
+	return file, nil
+}
 
-	var sharedDirectorieConfigs []vz.DirectorySharingDeviceConfiguration
-	for _, share := range config.SharedDirectories {
-		sharedDirectory := vz.NewSharedDirectory(share.Directory, false)
-		singleSharedDirectory := vz.NewSingleDirectoryShare(sharedDirectory)
+// removeOnClose removes a file when closed.
+type removeOnClose string
 
-		sharedDirectoryConfig := vz.NewVirtioFileSystemDeviceConfiguration(share.Tag)
-		sharedDirectoryConfig.SetDirectoryShare(singleSharedDirectory)
+func (path removeOnClose) Close() error {
+	return os.Remove(string(path))
+}
 
-		sharedDirectorieConfigs = append(sharedDirectorieConfigs, sharedDirectoryConfig)
+// Close releases the host resources, such as consoles, created by Spec, in
+// the reverse order of their creation.
+func (spec *MachineSpec) Close() error {
+	var firstErr error
+	for i := len(spec.closers) - 1; i >= 0; i-- {
+		if err := spec.closers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	vzConfig.SetDirectorySharingDevicesVirtualMachineConfiguration(sharedDirectorieConfigs)
-
-	return vzConfig, nil
+	spec.closers = nil
+	return firstErr
 }
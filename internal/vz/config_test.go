@@ -0,0 +1,148 @@
+package vz
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestVirtualMachineSerialPort_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		msg     string
+		json    string
+		want    VirtualMachineSerialPort
+		wantErr bool
+	}{
+		{
+			msg:  "legacy stdio",
+			json: `"-"`,
+			want: VirtualMachineSerialPort{Type: SerialPortStdio},
+		}, {
+			msg:  "legacy file",
+			json: `"/tmp/console.log"`,
+			want: VirtualMachineSerialPort{Type: SerialPortFile, Path: "/tmp/console.log"},
+		}, {
+			msg:  "typed",
+			json: `{"type": "unix", "path": "/tmp/console.sock"}`,
+			want: VirtualMachineSerialPort{Type: SerialPortUnix, Path: "/tmp/console.sock"},
+		}, {
+			msg:     "unknown field",
+			json:    `{"type": "pty", "device": "/dev/ttys001"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var got VirtualMachineSerialPort
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Wanted an error but got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("Wanted %+v but got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParsePortForward(t *testing.T) {
+	tests := []struct {
+		msg     string
+		s       string
+		want    VirtualMachinePortForward
+		wantErr bool
+	}{
+		{
+			msg:  "port only",
+			s:    "8080:80",
+			want: VirtualMachinePortForward{Network: "tcp", Address: "127.0.0.1:8080", GuestPort: 80},
+		}, {
+			msg:  "host and port",
+			s:    "tcp:0.0.0.0:8080:80",
+			want: VirtualMachinePortForward{Network: "tcp", Address: "0.0.0.0:8080", GuestPort: 80},
+		}, {
+			msg:  "unix socket",
+			s:    "unix:/tmp/docker.sock:2375",
+			want: VirtualMachinePortForward{Network: "unix", Address: "/tmp/docker.sock", GuestPort: 2375},
+		}, {
+			msg:     "missing guest port",
+			s:       "8080",
+			wantErr: true,
+		}, {
+			msg:     "guest port out of range",
+			s:       "8080:65536",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			got, err := ParsePortForward(tt.s)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Wanted an error but got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Wanted %+v but got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseNetworkInterface(t *testing.T) {
+	tests := []struct {
+		msg     string
+		s       string
+		want    VirtualMachineNetworkInterface
+		wantErr bool
+	}{
+		{
+			msg:  "nat",
+			s:    "nat",
+			want: VirtualMachineNetworkInterface{Type: NetworkNAT},
+		}, {
+			msg:  "bridged",
+			s:    "bridged:en0",
+			want: VirtualMachineNetworkInterface{Type: NetworkBridged, BridgeInterface: "en0"},
+		}, {
+			msg:  "file handle",
+			s:    "file-handle:/tmp/switch.sock",
+			want: VirtualMachineNetworkInterface{Type: NetworkFileHandle, Socket: "/tmp/switch.sock"},
+		}, {
+			msg:     "bridged without interface",
+			s:       "bridged",
+			wantErr: true,
+		}, {
+			msg:     "unknown attachment",
+			s:       "vmnet:shared",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			got, err := ParseNetworkInterface(tt.s)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Wanted an error but got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Wanted %+v but got %+v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package vz
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// ConfigVersion is the configuration document version written and
+// understood by this version of vz.
+const ConfigVersion = 1
+
+// ConfigDocument is the versioned, on-disk representation of a
+// VirtualMachineConfig.
+type ConfigDocument struct {
+	Version int                  `json:"version"`
+	Machine VirtualMachineConfig `json:"machine"`
+}
+
+// ReadConfig decodes a configuration document from r. Unknown fields and
+// unsupported document versions are rejected.
+func ReadConfig(r io.Reader) (*VirtualMachineConfig, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read config")
+	}
+
+	// Check the version first so that documents from a newer vz are
+	// reported as such instead of failing on their new fields.
+	var header struct {
+		Version *int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse config")
+	}
+	if header.Version == nil {
+		return nil, errors.New("Config version not specified")
+	}
+	if *header.Version != ConfigVersion {
+		return nil, errors.Errorf("Unsupported config version %d (supported: %d)", *header.Version, ConfigVersion)
+	}
+
+	var document ConfigDocument
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse config")
+	}
+
+	return &document.Machine, nil
+}
+
+// ReadConfigFile reads a configuration document from path, or from stdin
+// if path is "-".
+func ReadConfigFile(path string) (*VirtualMachineConfig, error) {
+	if path == "-" {
+		return ReadConfig(os.Stdin)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open config")
+	}
+	defer file.Close()
+
+	return ReadConfig(file)
+}
+
+// WriteConfigFile atomically writes config as a configuration document to
+// path. The file is only readable by its owner.
+func WriteConfigFile(path string, config *VirtualMachineConfig) error {
+	data, err := json.MarshalIndent(ConfigDocument{
+		Version: ConfigVersion,
+		Machine: *config,
+	}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode config")
+	}
+
+	return writeFileAtomic(path, append(data, '\n'), 0o600)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,135 @@
+package vz
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	vznet "github.com/brholstein/docker-machine-driver-vz/internal/net"
+)
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		msg     string
+		json    string
+		want    *VirtualMachineConfig
+		wantErr string
+	}{
+		{
+			msg:  "supported version",
+			json: `{"version": 1, "machine": {"kernel": "/bzImage", "initrd": "/initrd.img", "cmdLine": "console=hvc0", "cpus": 2, "memory": 2048}}`,
+			want: &VirtualMachineConfig{Kernel: "/bzImage", Initrd: "/initrd.img", CmdLine: "console=hvc0", CPUs: 2, Memory: 2048},
+		}, {
+			msg:     "missing version",
+			json:    `{"machine": {"cpus": 2}}`,
+			wantErr: "Config version not specified",
+		}, {
+			msg:     "unsupported version",
+			json:    `{"version": 2, "machine": {"cpus": 2, "newField": true}}`,
+			wantErr: "Unsupported config version 2 (supported: 1)",
+		}, {
+			msg:     "unknown field of the document",
+			json:    `{"version": 1, "machine": {}, "extra": true}`,
+			wantErr: `unknown field "extra"`,
+		}, {
+			msg:     "unknown field of the machine",
+			json:    `{"version": 1, "machine": {"cpu": 2}}`,
+			wantErr: `unknown field "cpu"`,
+		}, {
+			msg:     "invalid json",
+			json:    `{"version": 1,`,
+			wantErr: "Failed to parse config",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			got, err := ReadConfig(strings.NewReader(tt.json))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Wanted an error containing %q but got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("Wanted %+v but got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestReadConfigFile_Stdin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vz.json")
+	if err := os.WriteFile(path, []byte(`{"version": 1, "machine": {"cpus": 4}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	os.Stdin = stdin
+
+	config, err := ReadConfigFile("-")
+	if err != nil {
+		t.Fatalf("Unexpected error reading stdin: %s", err)
+	}
+	if config.CPUs != 4 {
+		t.Errorf("Wanted 4 CPUs but got %d", config.CPUs)
+	}
+}
+
+func TestWriteConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vz.json")
+	if err := os.WriteFile(path, []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mac, err := net.ParseMAC("52:54:00:12:34:56")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &VirtualMachineConfig{
+		Kernel:  "/bzImage",
+		Initrd:  "/initrd.img",
+		CmdLine: "console=hvc0",
+		CPUs:    2,
+		Memory:  2048,
+		Disks:   []VirtualMachineDiskConfig{{Path: "/disk.img"}},
+		NetworkInterfaces: []VirtualMachineNetworkInterface{
+			{MACAddress: vznet.FromNetHardwareAddr(mac)},
+		},
+		SharedDirectories: []VirtualMachineSharedDirectory{{Directory: "/Users/jane", Tag: "Home"}},
+	}
+	if err := WriteConfigFile(path, want); err != nil {
+		t.Fatalf("Unexpected error writing config: %s", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Wanted mode 0600 but got %#o", perm)
+	}
+	// The temporary file is renamed over the config, leaving nothing else.
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("Wanted only the config in %s but got %v (%v)", dir, entries, err)
+	}
+
+	got, err := ReadConfigFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error reading config: %s", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Wanted %+v but got %+v", want, got)
+	}
+}
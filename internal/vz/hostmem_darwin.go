@@ -0,0 +1,15 @@
+//go:build darwin
+// +build darwin
+
+package vz
+
+import "golang.org/x/sys/unix"
+
+// hostMemory returns the physical memory size of the host in bytes.
+func hostMemory() uint64 {
+	size, err := unix.SysctlUint64("hw.memsize")
+	if err != nil {
+		return 0
+	}
+	return size
+}
@@ -0,0 +1,10 @@
+//go:build !darwin
+// +build !darwin
+
+package vz
+
+// hostMemory returns the physical memory size of the host in bytes, or 0
+// when it isn't known.
+func hostMemory() uint64 {
+	return 0
+}
@@ -0,0 +1,54 @@
+package vz
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The launcher reports whether the virtual machine started with a single
+// line on its readiness pipe, which is then closed.
+const (
+	readyMessage       = "VM running"
+	startFailedMessage = "start failed: "
+)
+
+// ErrNoReadiness is returned by ReadReadiness when the launcher closed the
+// readiness pipe without reporting, e.g. because it crashed.
+var ErrNoReadiness = errors.New("launcher exited without reporting whether the virtual machine started")
+
+// WriteReadiness reports to the process starting the launcher that the
+// virtual machine is running, or that it failed to start with err.
+func WriteReadiness(w io.Writer, err error) error {
+	message := readyMessage
+	if err != nil {
+		// The report is a single line.
+		message = startFailedMessage + strings.ReplaceAll(err.Error(), "\n", " ")
+	}
+	_, err = fmt.Fprintln(w, message)
+	return err
+}
+
+// ReadReadiness waits for the report written by WriteReadiness, returning
+// the reason the virtual machine failed to start, if it did.
+func ReadReadiness(r io.Reader) error {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		if err == io.EOF {
+			return ErrNoReadiness
+		}
+		return errors.Wrap(err, "Failed to read launcher readiness")
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	switch {
+	case line == readyMessage:
+		return nil
+	case strings.HasPrefix(line, startFailedMessage):
+		return errors.New(strings.TrimPrefix(line, startFailedMessage))
+	}
+	return errors.Errorf("Unexpected launcher readiness %q", line)
+}
@@ -0,0 +1,51 @@
+package vz
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestReadReadiness(t *testing.T) {
+	tests := []struct {
+		msg     string
+		report  func(b *bytes.Buffer)
+		wantErr string
+	}{
+		{
+			msg:    "running",
+			report: func(b *bytes.Buffer) { WriteReadiness(b, nil) },
+		}, {
+			msg:     "start failed",
+			report:  func(b *bytes.Buffer) { WriteReadiness(b, errors.New("Failed to start VM:\nboom")) },
+			wantErr: "Failed to start VM: boom",
+		}, {
+			msg:     "no report",
+			report:  func(b *bytes.Buffer) {},
+			wantErr: ErrNoReadiness.Error(),
+		}, {
+			msg:     "garbage",
+			report:  func(b *bytes.Buffer) { b.WriteString("panic: oops\n") },
+			wantErr: `Unexpected launcher readiness "panic: oops"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var b bytes.Buffer
+			tt.report(&b)
+
+			err := ReadReadiness(&b)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unexpected error %q", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Wanted error %q but got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
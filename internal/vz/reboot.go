@@ -0,0 +1,69 @@
+package vz
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// consoleSettleTime is how long console output must have stopped before the
+// reboot message is considered not to be coming. The message may still be on
+// its way from the guest when the stop of the virtual machine is noticed.
+const consoleSettleTime = 250 * time.Millisecond
+
+// linuxRebootMessage is printed to the console by Linux right before it
+// resets the machine, and not when it powers it off.
+var linuxRebootMessage = []byte("reboot: Restarting system")
+
+// rebootWatcher scans guest console output for linuxRebootMessage. Virtual
+// machines stop the same way whether the guest powered off or rebooted, so
+// the console is what tells the two apart.
+type rebootWatcher struct {
+	mu        sync.Mutex
+	tail      []byte
+	requested bool
+	lastWrite time.Time
+}
+
+func (w *rebootWatcher) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.lastWrite = time.Now()
+	if w.requested {
+		return len(data), nil
+	}
+
+	// Keep enough of the output to find the message split across writes.
+	buf := append(w.tail, data...)
+	if bytes.Contains(buf, linuxRebootMessage) {
+		w.requested = true
+		w.tail = nil
+		return len(data), nil
+	}
+	if keep := len(linuxRebootMessage) - 1; len(buf) > keep {
+		buf = buf[len(buf)-keep:]
+	}
+	w.tail = append(w.tail[:0:0], buf...)
+	return len(data), nil
+}
+
+// rebootRequested reports whether the reboot message was seen, waiting for
+// console output to settle first.
+func (w *rebootWatcher) rebootRequested() bool {
+	start := time.Now()
+	for {
+		w.mu.Lock()
+		requested, lastWrite := w.requested, w.lastWrite
+		w.mu.Unlock()
+
+		if lastWrite.Before(start) {
+			lastWrite = start
+		}
+		wait := consoleSettleTime - time.Since(lastWrite)
+		if requested || wait <= 0 {
+			return requested
+		}
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,35 @@
+package vz
+
+import "testing"
+
+func TestRebootWatcher(t *testing.T) {
+	tests := []struct {
+		msg    string
+		writes []string
+		want   bool
+	}{
+		{
+			msg:    "reboot",
+			writes: []string{"[   12.3] reboot: Restarting system\r\n"},
+			want:   true,
+		}, {
+			msg:    "message split across writes",
+			writes: []string{"[   12.3] reboot: Rest", "arting system\r\n"},
+			want:   true,
+		}, {
+			msg:    "power off",
+			writes: []string{"[   12.3] reboot: Power down\r\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			w := &rebootWatcher{}
+			for _, data := range tt.writes {
+				w.Write([]byte(data))
+			}
+			if got := w.rebootRequested(); got != tt.want {
+				t.Errorf("Wanted reboot requested %t but got %t", tt.want, got)
+			}
+		})
+	}
+}
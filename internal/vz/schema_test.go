@@ -0,0 +1,74 @@
+package vz
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestConfigSchema(t *testing.T) {
+	data, err := ConfigSchema()
+	if err != nil {
+		t.Fatalf("Unexpected error generating schema: %s", err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Schema isn't valid JSON: %s", err)
+	}
+
+	// lookup returns the subschema at the path of keys.
+	lookup := func(keys ...string) interface{} {
+		var value interface{} = schema
+		for _, key := range keys {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			value = object[key]
+		}
+		return value
+	}
+
+	tests := []struct {
+		msg  string
+		keys []string
+		want interface{}
+	}{
+		{
+			msg:  "document",
+			keys: []string{"additionalProperties"},
+			want: false,
+		}, {
+			msg:  "version",
+			keys: []string{"properties", "version"},
+			want: map[string]interface{}{"const": float64(ConfigVersion)},
+		}, {
+			msg:  "required fields",
+			keys: []string{"properties", "machine", "required"},
+			want: []interface{}{"kernel", "initrd", "cmdLine", "cpus", "memory"},
+		}, {
+			msg:  "unknown fields",
+			keys: []string{"properties", "machine", "additionalProperties"},
+			want: false,
+		}, {
+			msg:  "unsigned integer",
+			keys: []string{"properties", "machine", "properties", "cpus"},
+			want: map[string]interface{}{"type": "integer", "minimum": float64(0)},
+		}, {
+			msg:  "text marshaler",
+			keys: []string{"properties", "machine", "properties", "networkInterfaces", "items", "properties", "macAddress"},
+			want: map[string]interface{}{"type": "string"},
+		}, {
+			msg:  "slice of structs",
+			keys: []string{"properties", "machine", "properties", "sharedDirectories", "items", "required"},
+			want: []interface{}{"directory", "tag"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			if got := lookup(tt.keys...); !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("Wanted %v but got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+//go:build darwin
+// +build darwin
+
+package vz
+
+import (
+	"log"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Code-Hex/vz"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// connectTimeout bounds how long ConnectToPort waits for the hypervisor,
+// which doesn't always complete connections to ports nobody listens on.
+const connectTimeout = 10 * time.Second
+
+func (m *codeHexMachine) ConnectToPort(port uint32) (net.Conn, error) {
+	m.socketMu.Lock()
+	defer m.socketMu.Unlock()
+
+	devices := m.vm.SocketDevices()
+	if len(devices) == 0 {
+		return nil, ErrNoSocketDevice
+	}
+
+	type result struct {
+		conn *vz.VirtioSocketConnection
+		err  error
+	}
+	results := make(chan result, 1)
+	devices[0].ConnectToPort(port, func(conn *vz.VirtioSocketConnection, err error) {
+		results <- result{conn: conn, err: err}
+	})
+
+	var r result
+	select {
+	case r = <-results:
+	case <-time.After(connectTimeout):
+		// Close the connection if the hypervisor completes it after all.
+		go func() {
+			select {
+			case r := <-results:
+				if r.err == nil {
+					r.conn.Close()
+				}
+			case <-m.done:
+			}
+		}()
+		return nil, errors.Errorf("Timed out connecting to vsock port %d", port)
+	}
+	if r.err != nil {
+		return nil, errors.Wrapf(r.err, "Failed to connect to vsock port %d", port)
+	}
+	defer r.conn.Close()
+
+	// The descriptor belongs to the connection object, so keep a copy.
+	fd, err := unix.Dup(int(r.conn.FileDescriptor()))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to duplicate vsock connection")
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, errors.Wrap(err, "Failed to duplicate vsock connection")
+	}
+
+	return &socketConn{
+		File:   os.NewFile(uintptr(fd), r.conn.ID()),
+		local:  r.conn.LocalAddr(),
+		remote: r.conn.RemoteAddr(),
+	}, nil
+}
+
+func (m *codeHexMachine) ListenOnPort(port uint32) (net.Listener, error) {
+	m.socketMu.Lock()
+	defer m.socketMu.Unlock()
+
+	devices := m.vm.SocketDevices()
+	if len(devices) == 0 {
+		return nil, ErrNoSocketDevice
+	}
+
+	l := &socketListener{
+		device: devices[0],
+		port:   port,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	l.listener = vz.NewVirtioSocketListener(func(conn *vz.VirtioSocketConnection, err error) {
+		if err != nil {
+			log.Printf("Failed to accept connection on vsock port %d: %v", port, err)
+			return
+		}
+		select {
+		case l.conns <- conn:
+		case <-l.closed:
+			conn.Close()
+		}
+	})
+	l.device.SetSocketListenerForPort(l.listener, port)
+	return l, nil
+}
+
+// socketListener accepts the connections of the guest to a vsock port.
+type socketListener struct {
+	device   *vz.VirtioSocketDevice
+	listener *vz.VirtioSocketListener
+	port     uint32
+	conns    chan net.Conn
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func (l *socketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *socketListener) Close() error {
+	l.once.Do(func() {
+		l.device.RemoveSocketListenerForPort(l.listener, l.port)
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *socketListener) Addr() net.Addr {
+	return &vz.Addr{CID: unix.VMADDR_CID_HOST, Port: l.port}
+}
+
+// socketConn is a vsock connection to the guest.
+type socketConn struct {
+	*os.File
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *socketConn) LocalAddr() net.Addr  { return c.local }
+func (c *socketConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *socketConn) CloseWrite() error {
+	rawConn, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var shutdownErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		shutdownErr = syscall.Shutdown(int(fd), syscall.SHUT_WR)
+	}); err != nil {
+		return err
+	}
+	return shutdownErr
+}
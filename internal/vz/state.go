@@ -0,0 +1,115 @@
+package vz
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StateFileName is the name of the file in a machine directory the
+// launcher publishes its Status to.
+const StateFileName = "vz.state"
+
+// ExitReason records why a virtual machine stopped.
+type ExitReason string
+
+const (
+	// ExitReasonGuestShutdown means the guest powered itself off.
+	ExitReasonGuestShutdown ExitReason = "guest-shutdown"
+	// ExitReasonGuestReboot means the guest rebooted, which stops the
+	// virtual machine until the launcher starts a new one.
+	ExitReasonGuestReboot ExitReason = "guest-reboot"
+	// ExitReasonHostSignal means the launcher was stopped by a signal.
+	ExitReasonHostSignal ExitReason = "host-signal"
+	// ExitReasonHostRequest means a stop was requested over the control API.
+	ExitReasonHostRequest ExitReason = "host-request"
+	// ExitReasonStartError means the virtual machine failed to start.
+	ExitReasonStartError ExitReason = "start-error"
+	// ExitReasonVMError means the hypervisor stopped the virtual machine
+	// because of an error.
+	ExitReasonVMError ExitReason = "vm-error"
+	// ExitReasonWatchdog means the launcher stopped the virtual machine
+	// because the guest stopped sending heartbeats.
+	ExitReasonWatchdog ExitReason = "watchdog"
+)
+
+// Health is the health of a guest, as judged by the watchdog.
+type Health string
+
+const (
+	// HealthWaiting means the guest has not sent a heartbeat yet.
+	HealthWaiting Health = "waiting"
+	// HealthHealthy means the guest sends heartbeats.
+	HealthHealthy Health = "healthy"
+	// HealthUnhealthy means the guest stopped sending heartbeats.
+	HealthUnhealthy Health = "unhealthy"
+)
+
+// IsFailure reports whether the virtual machine stopped because of an error.
+func (r ExitReason) IsFailure() bool {
+	return r == ExitReasonStartError || r == ExitReasonVMError || r == ExitReasonWatchdog
+}
+
+// Exit describes how a virtual machine last stopped.
+type Exit struct {
+	Timestamp time.Time  `json:"timestamp"`
+	Reason    ExitReason `json:"reason"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Restart records a virtual machine the launcher restarted after it stopped.
+type Restart struct {
+	// Exit is how the virtual machine stopped before the restart.
+	Exit Exit `json:"exit"`
+	// Attempt counts the restarts of the launcher, starting at 1.
+	Attempt uint `json:"attempt"`
+	// Backoff is how many seconds the launcher waited before restarting.
+	Backoff uint `json:"backoff"`
+}
+
+// Status is the state of a virtual machine as published by its launcher.
+type Status struct {
+	// State is the name of the virtual machine state.
+	State     string    `json:"state"`
+	Timestamp time.Time `json:"timestamp"`
+	// Pid is the process id of the launcher.
+	Pid int `json:"pid"`
+	// Exited is set once the launcher has exited.
+	Exited bool `json:"exited"`
+	// LastExit is kept across launchers until the virtual machine stops
+	// again.
+	LastExit *Exit `json:"lastExit,omitempty"`
+	// Restarts are the most recent restarts done by the launcher, oldest
+	// first.
+	Restarts []Restart `json:"restarts,omitempty"`
+	// Health is set for virtual machines with a watchdog.
+	Health Health `json:"health,omitempty"`
+}
+
+// WriteStateFile atomically replaces the state file at path with status.
+func WriteStateFile(path string, status *Status) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode state")
+	}
+
+	return writeFileAtomic(path, append(data, '\n'), 0o644)
+}
+
+// ReadStateFile reads the state file at path. A missing state file is
+// reported as an error satisfying os.IsNotExist.
+func ReadStateFile(path string) (*Status, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var status Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse state file %s", path)
+	}
+
+	return &status, nil
+}
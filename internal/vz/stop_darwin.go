@@ -0,0 +1,49 @@
+//go:build darwin
+// +build darwin
+
+package vz
+
+/*
+#cgo darwin CFLAGS: -x objective-c -fno-objc-arc
+#cgo darwin LDFLAGS: -lobjc -framework Foundation -framework Virtualization
+#include <stdlib.h>
+#include <string.h>
+#import <Virtualization/Virtualization.h>
+
+// stopMachine stops machine without asking the guest, waiting for it to
+// complete. On failure it returns a description to be freed by the caller.
+static char *stopMachine(void *machine, void *queue)
+{
+	if (@available(macOS 12, *)) {
+		dispatch_semaphore_t done = dispatch_semaphore_create(0);
+		__block char *error = NULL;
+		dispatch_async((dispatch_queue_t)queue, ^{
+			[(VZVirtualMachine *)machine stopWithCompletionHandler:^(NSError *err) {
+				if (err != nil) {
+					error = strdup([err.localizedDescription UTF8String]);
+				}
+				dispatch_semaphore_signal(done);
+			}];
+		});
+		dispatch_semaphore_wait(done, DISPATCH_TIME_FOREVER);
+		dispatch_release(done);
+		return error;
+	}
+	return strdup("Stopping a virtual machine requires macOS 12 or later");
+}
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+func (m *codeHexMachine) Stop() error {
+	if cErr := C.stopMachine(m.vm.Ptr(), m.vm.DispatchQueue()); cErr != nil {
+		defer C.free(unsafe.Pointer(cErr))
+		return errors.Errorf("Failed to stop: %s", C.GoString(cErr))
+	}
+	return nil
+}
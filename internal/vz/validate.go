@@ -0,0 +1,337 @@
+package vz
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// MinCPUs is the minimum number of CPUs of a virtual machine.
+	MinCPUs = 1
+	// MinMemory is the minimum memory size of a virtual machine, in MiB.
+	MinMemory = 128
+
+	// maxUnixSocketPath is the size of sun_path on macOS.
+	maxUnixSocketPath = 104
+)
+
+// FieldError is a problem with a single field of a VirtualMachineConfig.
+type FieldError struct {
+	// Path is the JSON path of the field, e.g. "disks[1].path".
+	Path    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationError lists every problem found in a VirtualMachineConfig.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldError := range e.Errors {
+		messages[i] = fieldError.Error()
+	}
+	return "Invalid configuration: " + strings.Join(messages, "; ")
+}
+
+type validator struct {
+	errors []FieldError
+}
+
+func (v *validator) addf(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks config without involving the hypervisor, returning a
+// *ValidationError describing every problem found.
+func (config *VirtualMachineConfig) Validate() error {
+	v := &validator{}
+
+	maxCPUs := runtime.NumCPU()
+	if config.CPUs < MinCPUs || int(config.CPUs) > maxCPUs {
+		v.addf("cpus", "must be between %d and %d, got %d", MinCPUs, maxCPUs, config.CPUs)
+	}
+
+	if config.Memory < MinMemory {
+		v.addf("memory", "must be at least %d MiB, got %d", MinMemory, config.Memory)
+	} else if maxMemory := hostMemory() / (1024 * 1024); maxMemory > 0 && uint64(config.Memory) > maxMemory {
+		v.addf("memory", "must be at most %d MiB, got %d", maxMemory, config.Memory)
+	}
+
+	switch config.bootMode() {
+	case BootModeLinux:
+		if config.Kernel == "" {
+			v.addf("kernel", "is required")
+		} else {
+			v.checkReadable("kernel", config.Kernel, false)
+		}
+		if config.Initrd != "" {
+			v.checkReadable("initrd", config.Initrd, false)
+		}
+		if config.EFIVariableStore != "" {
+			v.addf("efiVariableStore", "is only supported in %s boot mode", BootModeEFI)
+		}
+	case BootModeEFI:
+		if config.Kernel != "" {
+			v.addf("kernel", "is not supported in %s boot mode", BootModeEFI)
+		}
+		if config.Initrd != "" {
+			v.addf("initrd", "is not supported in %s boot mode", BootModeEFI)
+		}
+		if config.CmdLine != "" {
+			v.addf("cmdLine", "is not supported in %s boot mode", BootModeEFI)
+		}
+		if config.FirstBootCmdLine != "" {
+			v.addf("firstBootCmdLine", "is not supported in %s boot mode", BootModeEFI)
+		}
+		if config.EFIVariableStore == "" {
+			v.addf("efiVariableStore", "is required in %s boot mode", BootModeEFI)
+		} else if _, err := os.Stat(config.EFIVariableStore); err == nil {
+			v.checkReadable("efiVariableStore", config.EFIVariableStore, true)
+		} else if info, err := os.Stat(filepath.Dir(config.EFIVariableStore)); err != nil {
+			v.addf("efiVariableStore", "%s", err)
+		} else if !info.IsDir() {
+			v.addf("efiVariableStore", "%s is not a directory", filepath.Dir(config.EFIVariableStore))
+		}
+	default:
+		v.addf("bootMode", "must be %s or %s, got %q", BootModeLinux, BootModeEFI, config.BootMode)
+	}
+
+	diskPaths := map[string]int{}
+	for i, disk := range config.Disks {
+		path := fmt.Sprintf("disks[%d].path", i)
+		if disk.Path == "" {
+			v.addf(path, "is required")
+			continue
+		}
+		v.checkReadable(path, disk.Path, !disk.ReadOnly)
+
+		key := filepath.Clean(disk.Path)
+		if first, ok := diskPaths[key]; ok {
+			if !disk.ReadOnly || !config.Disks[first].ReadOnly {
+				v.addf(path, "writable disk %s is already attached as disks[%d]", disk.Path, first)
+			}
+			continue
+		}
+		diskPaths[key] = i
+	}
+
+	macAddresses := map[string]int{}
+	for i, network := range config.NetworkInterfaces {
+		v.checkNetworkAttachment(fmt.Sprintf("networkInterfaces[%d]", i), network)
+
+		path := fmt.Sprintf("networkInterfaces[%d].macAddress", i)
+		macAddr := network.MACAddress.ToNetHardwareAddr()
+		if len(macAddr) == 0 {
+			continue
+		}
+
+		if macAddr[0]&0x01 != 0 {
+			v.addf(path, "%s is a multicast address", macAddr)
+		}
+
+		key := macAddr.String()
+		if first, ok := macAddresses[key]; ok {
+			v.addf(path, "%s is already used by networkInterfaces[%d]", macAddr, first)
+			continue
+		}
+		macAddresses[key] = i
+	}
+
+	tags := map[string]int{}
+	for i, share := range config.SharedDirectories {
+		path := fmt.Sprintf("sharedDirectories[%d]", i)
+
+		if info, err := os.Stat(share.Directory); err != nil {
+			v.addf(path+".directory", "%s", err)
+		} else if !info.IsDir() {
+			v.addf(path+".directory", "%s is not a directory", share.Directory)
+		}
+
+		if share.Tag == "" {
+			v.addf(path+".tag", "is required")
+			continue
+		}
+		if first, ok := tags[share.Tag]; ok {
+			v.addf(path+".tag", "%q is already used by sharedDirectories[%d]", share.Tag, first)
+			continue
+		}
+		tags[share.Tag] = i
+	}
+
+	for i, port := range config.SerialPorts {
+		path := fmt.Sprintf("serialPorts[%d]", i)
+
+		switch port.Type {
+		case SerialPortFile, SerialPortUnix:
+			if port.Path == "" {
+				v.addf(path+".path", "is required for %s ports", port.Type)
+			}
+		case SerialPortStdio:
+			if port.Path != "" {
+				v.addf(path+".path", "is not supported for %s ports", port.Type)
+			}
+		case SerialPortPTY:
+		default:
+			v.addf(path+".type", "must be one of %s, %s, %s or %s, got %q",
+				SerialPortFile, SerialPortStdio, SerialPortPTY, SerialPortUnix, port.Type)
+			continue
+		}
+
+		if port.Append && port.Type != SerialPortFile {
+			v.addf(path+".append", "is only supported for %s ports", SerialPortFile)
+		}
+		if port.Type == SerialPortUnix && len(port.Path) >= maxUnixSocketPath {
+			v.addf(path+".path", "must be shorter than %d bytes for a Unix socket", maxUnixSocketPath)
+		}
+
+		if port.Log == nil {
+			continue
+		}
+		if port.Type != SerialPortUnix {
+			v.addf(path+".log", "is only supported for %s ports", SerialPortUnix)
+		}
+		if port.Log.Path == "" {
+			v.addf(path+".log.path", "is required")
+		}
+		if port.Log.MaxSize <= 0 {
+			v.addf(path+".log.maxSize", "must be positive, got %d", port.Log.MaxSize)
+		}
+		if port.Log.MaxFiles < 0 {
+			v.addf(path+".log.maxFiles", "must not be negative, got %d", port.Log.MaxFiles)
+		}
+	}
+
+	listenAddresses := map[string]int{}
+	for i, forward := range config.PortForwards {
+		path := fmt.Sprintf("portForwards[%d]", i)
+
+		switch forward.Network {
+		case "tcp":
+			if _, err := net.ResolveTCPAddr(forward.Network, forward.Address); err != nil {
+				v.addf(path+".address", "%s", err)
+			}
+		case "unix":
+			if forward.Address == "" {
+				v.addf(path+".address", "is required")
+			} else if len(forward.Address) >= maxUnixSocketPath {
+				v.addf(path+".address", "must be shorter than %d bytes for a Unix socket", maxUnixSocketPath)
+			}
+		default:
+			v.addf(path+".network", "must be tcp or unix, got %q", forward.Network)
+			continue
+		}
+
+		if forward.GuestPort == 0 {
+			v.addf(path+".guestPort", "is required")
+		}
+
+		key := forward.Network + ":" + forward.Address
+		if first, ok := listenAddresses[key]; ok {
+			v.addf(path+".address", "%s is already used by portForwards[%d]", forward.Address, first)
+			continue
+		}
+		listenAddresses[key] = i
+	}
+
+	if config.Watchdog != nil && config.Watchdog.Timeout == 0 {
+		v.addf("watchdog.timeout", "is required")
+	}
+
+	if policy := config.RestartPolicy; policy != nil {
+		switch policy.Mode {
+		case RestartNo, RestartOnFailure, RestartAlways:
+		default:
+			v.addf("restartPolicy.mode", "must be %s, %s or %s, got %q", RestartNo, RestartOnFailure, RestartAlways, policy.Mode)
+		}
+
+		withDefaults := policy.WithDefaults()
+		if withDefaults.MaxBackoff < withDefaults.Backoff {
+			v.addf("restartPolicy.maxBackoff", "must be at least the backoff of %d seconds, got %d", withDefaults.Backoff, withDefaults.MaxBackoff)
+		}
+	}
+
+	if len(v.errors) > 0 {
+		return &ValidationError{Errors: v.errors}
+	}
+	return nil
+}
+
+func (v *validator) checkNetworkAttachment(path string, network VirtualMachineNetworkInterface) {
+	switch network.Attachment() {
+	case NetworkNAT:
+	case NetworkBridged:
+		if network.BridgeInterface == "" {
+			v.addf(path+".bridgeInterface", "is required for %s interfaces", NetworkBridged)
+		} else {
+			v.checkBridgeInterface(path+".bridgeInterface", network.BridgeInterface)
+		}
+	case NetworkFileHandle:
+		if network.Socket == "" {
+			v.addf(path+".socket", "is required for %s interfaces", NetworkFileHandle)
+		} else if info, err := os.Stat(network.Socket); err != nil {
+			v.addf(path+".socket", "%s", err)
+		} else if info.Mode()&os.ModeSocket == 0 {
+			v.addf(path+".socket", "%s is not a socket", network.Socket)
+		}
+	default:
+		v.addf(path+".type", "must be %s, %s or %s, got %q", NetworkNAT, NetworkBridged, NetworkFileHandle, network.Type)
+		return
+	}
+
+	if network.BridgeInterface != "" && network.Attachment() != NetworkBridged {
+		v.addf(path+".bridgeInterface", "is only supported for %s interfaces", NetworkBridged)
+	}
+	if network.Socket != "" && network.Attachment() != NetworkFileHandle {
+		v.addf(path+".socket", "is only supported for %s interfaces", NetworkFileHandle)
+	}
+}
+
+// checkBridgeInterface reports if the host can't bridge to iface. Hosts that
+// can't list their interfaces are not checked.
+func (v *validator) checkBridgeInterface(path, iface string) {
+	interfaces, err := BridgeInterfaces()
+	if err != nil {
+		return
+	}
+
+	names := make([]string, len(interfaces))
+	for i, bridgeInterface := range interfaces {
+		if bridgeInterface.Identifier == iface {
+			return
+		}
+		names[i] = bridgeInterface.Identifier
+	}
+	v.addf(path, "%s is not a host interface that can be bridged to (available: %s)", iface, strings.Join(names, ", "))
+}
+
+// checkReadable reports if file can't be opened for reading, or for writing
+// as well if writable is set.
+func (v *validator) checkReadable(path, file string, writable bool) {
+	flag := os.O_RDONLY
+	if writable {
+		flag = os.O_RDWR
+	}
+
+	f, err := os.OpenFile(file, flag, 0)
+	if err != nil {
+		v.addf(path, "%s", err)
+		return
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err != nil {
+		v.addf(path, "%s", err)
+	} else if info.IsDir() {
+		v.addf(path, "%s is a directory", file)
+	}
+}
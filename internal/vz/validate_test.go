@@ -0,0 +1,207 @@
+package vz
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	vznet "github.com/brholstein/docker-machine-driver-vz/internal/net"
+)
+
+func TestVirtualMachineConfig_Validate(t *testing.T) {
+	dir := t.TempDir()
+	kernel := filepath.Join(dir, "bzImage")
+	disk := filepath.Join(dir, "disk.img")
+	for _, file := range []string{kernel, disk} {
+		if err := os.WriteFile(file, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	missing := filepath.Join(dir, "missing")
+
+	mac := func(s string) vznet.HardwareAddr {
+		var a vznet.HardwareAddr
+		if err := a.UnmarshalText([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	valid := func() *VirtualMachineConfig {
+		return &VirtualMachineConfig{
+			Kernel: kernel,
+			CPUs:   1,
+			Memory: 512,
+			Disks: []VirtualMachineDiskConfig{
+				{Path: disk},
+			},
+			NetworkInterfaces: []VirtualMachineNetworkInterface{
+				{MACAddress: mac("02:00:00:00:00:01")},
+			},
+			SharedDirectories: []VirtualMachineSharedDirectory{
+				{Directory: dir, Tag: "Home"},
+			},
+		}
+	}
+
+	tests := []struct {
+		msg       string
+		modify    func(c *VirtualMachineConfig)
+		wantPaths []string
+	}{
+		{
+			msg:    "valid config",
+			modify: func(c *VirtualMachineConfig) {},
+		}, {
+			msg: "cpu and memory bounds",
+			modify: func(c *VirtualMachineConfig) {
+				c.CPUs = 0
+				c.Memory = 64
+			},
+			wantPaths: []string{"cpus", "memory"},
+		}, {
+			msg: "missing files",
+			modify: func(c *VirtualMachineConfig) {
+				c.Kernel = missing
+				c.Initrd = dir
+				c.Disks[0].Path = missing
+			},
+			wantPaths: []string{"kernel", "initrd", "disks[0].path"},
+		}, {
+			msg: "efi boot mode",
+			modify: func(c *VirtualMachineConfig) {
+				c.BootMode = BootModeEFI
+				c.Kernel = ""
+				c.EFIVariableStore = filepath.Join(dir, "efi-variable-store")
+			},
+		}, {
+			msg: "efi boot mode with a kernel and no variable store",
+			modify: func(c *VirtualMachineConfig) {
+				c.BootMode = BootModeEFI
+				c.CmdLine = "console=hvc0"
+				c.FirstBootCmdLine = "console=hvc0 ignition.firstboot"
+			},
+			wantPaths: []string{"kernel", "cmdLine", "firstBootCmdLine", "efiVariableStore"},
+		}, {
+			msg: "unknown boot mode",
+			modify: func(c *VirtualMachineConfig) {
+				c.BootMode = "bios"
+			},
+			wantPaths: []string{"bootMode"},
+		}, {
+			msg: "writable disk attached twice",
+			modify: func(c *VirtualMachineConfig) {
+				c.Disks = append(c.Disks, VirtualMachineDiskConfig{Path: disk, ReadOnly: true})
+			},
+			wantPaths: []string{"disks[1].path"},
+		}, {
+			msg: "read-only disk attached twice",
+			modify: func(c *VirtualMachineConfig) {
+				c.Disks[0].ReadOnly = true
+				c.Disks = append(c.Disks, VirtualMachineDiskConfig{Path: disk, ReadOnly: true})
+			},
+		}, {
+			msg: "duplicate and multicast MAC addresses",
+			modify: func(c *VirtualMachineConfig) {
+				c.NetworkInterfaces = append(c.NetworkInterfaces,
+					VirtualMachineNetworkInterface{MACAddress: mac("02:00:00:00:00:01")},
+					VirtualMachineNetworkInterface{MACAddress: mac("01:00:5e:00:00:01")},
+					VirtualMachineNetworkInterface{},
+				)
+			},
+			wantPaths: []string{"networkInterfaces[1].macAddress", "networkInterfaces[2].macAddress"},
+		}, {
+			msg: "network attachments",
+			modify: func(c *VirtualMachineConfig) {
+				c.NetworkInterfaces = append(c.NetworkInterfaces,
+					VirtualMachineNetworkInterface{Type: NetworkBridged},
+					VirtualMachineNetworkInterface{Type: NetworkFileHandle, Socket: kernel},
+					VirtualMachineNetworkInterface{Type: NetworkNAT, Socket: missing},
+					VirtualMachineNetworkInterface{Type: "vmnet"},
+				)
+			},
+			wantPaths: []string{
+				"networkInterfaces[1].bridgeInterface", "networkInterfaces[2].socket",
+				"networkInterfaces[3].socket", "networkInterfaces[4].type",
+			},
+		}, {
+			msg: "duplicate share tags",
+			modify: func(c *VirtualMachineConfig) {
+				c.SharedDirectories = append(c.SharedDirectories,
+					VirtualMachineSharedDirectory{Directory: kernel, Tag: "Home"},
+				)
+			},
+			wantPaths: []string{"sharedDirectories[1].directory", "sharedDirectories[1].tag"},
+		}, {
+			msg: "serial ports",
+			modify: func(c *VirtualMachineConfig) {
+				c.SerialPorts = []VirtualMachineSerialPort{
+					{Type: SerialPortPTY},
+					{Type: SerialPortUnix},
+					{Type: SerialPortStdio, Append: true},
+					{Type: "tcp"},
+					{Type: SerialPortFile, Path: missing, Log: &VirtualMachineSerialLog{Path: missing}},
+				}
+			},
+			wantPaths: []string{
+				"serialPorts[1].path", "serialPorts[2].append", "serialPorts[3].type",
+				"serialPorts[4].log", "serialPorts[4].log.maxSize",
+			},
+		}, {
+			msg: "port forwards",
+			modify: func(c *VirtualMachineConfig) {
+				c.PortForwards = []VirtualMachinePortForward{
+					{Network: "tcp", Address: "127.0.0.1:8080", GuestPort: 80},
+					{Network: "tcp", Address: "127.0.0.1:8080", GuestPort: 81},
+					{Network: "tcp", Address: "nowhere", GuestPort: 80},
+					{Network: "udp", Address: "127.0.0.1:53", GuestPort: 53},
+					{Network: "unix", Address: filepath.Join(dir, "docker.sock")},
+				}
+			},
+			wantPaths: []string{
+				"portForwards[1].address", "portForwards[2].address",
+				"portForwards[3].network", "portForwards[4].guestPort",
+			},
+		}, {
+			msg: "restart policy",
+			modify: func(c *VirtualMachineConfig) {
+				c.RestartPolicy = &VirtualMachineRestartPolicy{Mode: "sometimes", Backoff: 600}
+			},
+			wantPaths: []string{"restartPolicy.mode", "restartPolicy.maxBackoff"},
+		}, {
+			msg: "watchdog without timeout",
+			modify: func(c *VirtualMachineConfig) {
+				c.Watchdog = &VirtualMachineWatchdog{Restart: true}
+			},
+			wantPaths: []string{"watchdog.timeout"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			config := valid()
+			tt.modify(config)
+
+			err := config.Validate()
+			if len(tt.wantPaths) == 0 {
+				if err != nil {
+					t.Errorf("Unexpected error %q", err)
+				}
+				return
+			}
+
+			validationErr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("Wanted a *ValidationError but got %v", err)
+			}
+
+			var gotPaths []string
+			for _, fieldErr := range validationErr.Errors {
+				gotPaths = append(gotPaths, fieldErr.Path)
+			}
+			if !reflect.DeepEqual(tt.wantPaths, gotPaths) {
+				t.Errorf("Wanted errors for %q but got %q", tt.wantPaths, validationErr)
+			}
+		})
+	}
+}
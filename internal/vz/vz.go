@@ -1,27 +1,45 @@
 package vz
 
-import (
-	"net"
+// VirtualMachine is a Machine together with the host resources, such as
+// consoles, created for its devices.
+type VirtualMachine struct {
+	Machine
+	spec *MachineSpec
+}
 
-	"github.com/Code-Hex/vz"
-)
+// NewVirtualMachine validates config and creates the virtual machine it
+// describes on backend.
+func NewVirtualMachine(backend Backend, config *VirtualMachineConfig) (*VirtualMachine, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 
-func NewVirtualMachine(config *VirtualMachineConfig) (*vz.VirtualMachine, error) {
-	vzConfig, err := config.ConvertToVZ()
+	spec, err := config.Spec()
 	if err != nil {
 		return nil, err
 	}
 
-	validated, err := vzConfig.Validate()
-	if !validated || err != nil {
+	machine, err := backend.NewMachine(spec)
+	if err != nil {
+		spec.Close()
 		return nil, err
 	}
 
-	vm := vz.NewVirtualMachine(vzConfig)
+	return &VirtualMachine{Machine: machine, spec: spec}, nil
+}
 
-	return vm, nil
+// RebootRequested reports whether the guest asked to be rebooted before it
+// stopped. Only guests with a serial port of type unix as their console are
+// recognized.
+func (vm *VirtualMachine) RebootRequested() bool {
+	if vm.spec.reboot == nil {
+		return false
+	}
+	return vm.spec.reboot.rebootRequested()
 }
 
-func NewRandomLocallyAdministeredHardwareAddr() net.HardwareAddr {
-	return vz.NewRandomLocallyAdministeredMACAddress().HardwareAddr()
+// Close releases the host resources of the virtual machine. It must only be
+// called once the virtual machine stopped.
+func (vm *VirtualMachine) Close() error {
+	return vm.spec.Close()
 }
@@ -0,0 +1,283 @@
+// Package vztest provides an in-memory vz.Backend for tests.
+package vztest
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/brholstein/docker-machine-driver-vz/internal/vz"
+	"github.com/pkg/errors"
+)
+
+// Backend is a vz.Backend that records the virtual machines it creates and
+// simulates their state transitions.
+type Backend struct {
+	// NewMachineError, if set, is returned instead of creating a machine.
+	NewMachineError error
+	// StartError, if set, is returned by Start of the machines created.
+	StartError error
+	// NoStopRequests makes CanRequestStop of the machines created report
+	// false, simulating a guest that can't be asked to stop.
+	NoStopRequests bool
+	// VsockHandler, if set, plays the guest side of the connections made
+	// with ConnectToPort. Otherwise connections are refused.
+	VsockHandler func(port uint32, conn net.Conn)
+
+	mu       sync.Mutex
+	machines []*Machine
+}
+
+var _ vz.Backend = (*Backend)(nil)
+
+func NewBackend() *Backend {
+	return &Backend{}
+}
+
+func (b *Backend) NewMachine(spec *vz.MachineSpec) (vz.Machine, error) {
+	if b.NewMachineError != nil {
+		return nil, b.NewMachineError
+	}
+
+	m := &Machine{
+		Spec:           spec,
+		startError:     b.StartError,
+		noStopRequests: b.NoStopRequests,
+		vsockHandler:   b.VsockHandler,
+		notify:         make(chan vz.State, 64),
+		targetMemory:   spec.MemorySize,
+	}
+
+	b.mu.Lock()
+	b.machines = append(b.machines, m)
+	b.mu.Unlock()
+
+	return m, nil
+}
+
+// Machines returns the machines created so far, oldest first.
+func (b *Backend) Machines() []*Machine {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]*Machine(nil), b.machines...)
+}
+
+// Machine is a simulated virtual machine. Every state change is delivered
+// on its notification channel, which holds a limited number of changes
+// that have not been received yet.
+type Machine struct {
+	// Spec is the device graph the machine was created from.
+	Spec *vz.MachineSpec
+
+	startError     error
+	noStopRequests bool
+	vsockHandler   func(port uint32, conn net.Conn)
+	notify         chan vz.State
+
+	mu           sync.Mutex
+	state        vz.State
+	targetMemory uint64
+	listeners    map[uint32]*listener
+}
+
+var _ vz.Machine = (*Machine)(nil)
+
+// SetState simulates a state change initiated by the guest or the
+// hypervisor, e.g. the guest powering off.
+func (m *Machine) SetState(state vz.State) {
+	m.mu.Lock()
+	m.state = state
+	m.mu.Unlock()
+
+	m.notify <- state
+}
+
+func (m *Machine) Start() error {
+	if m.State() != vz.StateStopped {
+		return errors.Errorf("Unable to start in state %s", m.State())
+	}
+
+	m.SetState(vz.StateStarting)
+	if m.startError != nil {
+		m.SetState(vz.StateStopped)
+		return m.startError
+	}
+	m.SetState(vz.StateRunning)
+	return nil
+}
+
+func (m *Machine) CanRequestStop() bool {
+	return !m.noStopRequests && m.State() == vz.StateRunning
+}
+
+// RequestStop simulates a guest that shuts down when asked to.
+func (m *Machine) RequestStop() error {
+	if !m.CanRequestStop() {
+		return errors.Errorf("Unable to request stop in state %s", m.State())
+	}
+
+	m.SetState(vz.StateStopped)
+	return nil
+}
+
+func (m *Machine) Stop() error {
+	if state := m.State(); state != vz.StateRunning && state != vz.StatePaused {
+		return errors.Errorf("Unable to stop in state %s", state)
+	}
+
+	m.SetState(vz.StateStopped)
+	return nil
+}
+
+func (m *Machine) CanPause() bool {
+	return m.State() == vz.StateRunning
+}
+
+func (m *Machine) Pause() error {
+	if !m.CanPause() {
+		return errors.Errorf("Unable to pause in state %s", m.State())
+	}
+
+	m.SetState(vz.StatePausing)
+	m.SetState(vz.StatePaused)
+	return nil
+}
+
+func (m *Machine) CanResume() bool {
+	return m.State() == vz.StatePaused
+}
+
+func (m *Machine) Resume() error {
+	if !m.CanResume() {
+		return errors.Errorf("Unable to resume in state %s", m.State())
+	}
+
+	m.SetState(vz.StateResuming)
+	m.SetState(vz.StateRunning)
+	return nil
+}
+
+func (m *Machine) SetTargetMemorySize(size uint64) error {
+	if !m.Spec.MemoryBalloonDevice {
+		return vz.ErrNoMemoryBalloon
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.targetMemory = size
+	return nil
+}
+
+func (m *Machine) TargetMemorySize() (uint64, error) {
+	if !m.Spec.MemoryBalloonDevice {
+		return 0, vz.ErrNoMemoryBalloon
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.targetMemory, nil
+}
+
+func (m *Machine) ConnectToPort(port uint32) (net.Conn, error) {
+	if !m.Spec.SocketDevice {
+		return nil, vz.ErrNoSocketDevice
+	}
+	if m.State() != vz.StateRunning || m.vsockHandler == nil {
+		return nil, errors.Errorf("Connection to vsock port %d refused", port)
+	}
+
+	host, guest := net.Pipe()
+	go m.vsockHandler(port, guest)
+	return host, nil
+}
+
+func (m *Machine) ListenOnPort(port uint32) (net.Listener, error) {
+	if !m.Spec.SocketDevice {
+		return nil, vz.ErrNoSocketDevice
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.listeners == nil {
+		m.listeners = map[uint32]*listener{}
+	}
+	if _, ok := m.listeners[port]; ok {
+		return nil, errors.Errorf("vsock port %d is already listened on", port)
+	}
+	l := &listener{
+		machine: m,
+		port:    port,
+		conns:   make(chan net.Conn),
+		closed:  make(chan struct{}),
+	}
+	m.listeners[port] = l
+	return l, nil
+}
+
+// DialHost simulates the guest connecting to a vsock port the host listens
+// on with ListenOnPort.
+func (m *Machine) DialHost(port uint32) (net.Conn, error) {
+	m.mu.Lock()
+	l, ok := m.listeners[port]
+	m.mu.Unlock()
+	if !ok {
+		return nil, errors.Errorf("Connection to vsock port %d refused", port)
+	}
+
+	host, guest := net.Pipe()
+	select {
+	case l.conns <- host:
+		return guest, nil
+	case <-l.closed:
+		return nil, errors.Errorf("Connection to vsock port %d refused", port)
+	}
+}
+
+// listener is a vsock port the host listens on.
+type listener struct {
+	machine *Machine
+	port    uint32
+	conns   chan net.Conn
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() {
+		l.machine.mu.Lock()
+		delete(l.machine.listeners, l.port)
+		l.machine.mu.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return vsockAddr(l.port)
+}
+
+type vsockAddr uint32
+
+func (a vsockAddr) Network() string { return "vsock" }
+func (a vsockAddr) String() string  { return fmt.Sprintf("2:%d", uint32(a)) }
+
+func (m *Machine) State() vz.State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+func (m *Machine) StateChangedNotify() <-chan vz.State {
+	return m.notify
+}
@@ -0,0 +1,8 @@
+module github.com/Code-Hex/vz
+
+go 1.16
+
+require (
+	github.com/rs/xid v1.2.1
+	golang.org/x/sys v0.0.0-20211210111614-af8b64212486
+)